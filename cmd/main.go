@@ -12,6 +12,7 @@ import (
 
 	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/config"
 	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/handler"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/health"
 	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/service"
 	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/transport"
 	"github.com/gin-gonic/gin"
@@ -35,12 +36,28 @@ func main() {
 		log.Fatalf("Fatal error creating redis config: %v", err)
 	}
 
-	kafka, err := transport.NewKafkaClient(config.KafkaBrokers, config.KafkaConsumerGroup, config.MaxRetry, time.Duration(config.MaxRetry))
+	kafkaTopics := append([]string{config.KafkaProduceTopic}, config.KafkaConsumeTopics...)
+
+	reconnect := transport.NewReconnectPolicy(config)
+
+	kafka, err := transport.NewKafkaClient(config.KafkaBrokers, config.KafkaConsumerGroup, kafkaTopics, reconnect)
 	if err != nil {
 		log.Fatalf("Fatal error creating kafka config: %v", err)
 	}
 
-	websocket := transport.NewWSClient(config.WsServerURL, time.Duration(config.WsPingPeriod), config.WsPinMaxError, config.MaxRetry, time.Duration(config.MaxRetry))
+	websocket := transport.NewWSClient(config.WsServerURL, time.Duration(config.WsPingPeriod)*time.Millisecond, time.Duration(config.WsPongWait)*time.Millisecond, config.WsPinMaxError, reconnect)
+
+	sendPolicy, err := transport.ParseSendPolicy(config.WsSendPolicy)
+	if err != nil {
+		log.Fatalf("Fatal error creating websocket config: %v", err)
+	}
+	websocket.SetSendQueue(config.WsSendQueue, sendPolicy)
+
+	dialer, header, err := transport.NewWSDialer(config)
+	if err != nil {
+		log.Fatalf("Fatal error creating websocket config: %v", err)
+	}
+	websocket.SetDialer(dialer, header)
 
 	nats, err := transport.NewNatsClient(config.NatsURL)
 
@@ -48,7 +65,18 @@ func main() {
 		log.Fatalf("Fatal error creating nats config: %v", err)
 	}
 
-	streamService := service.NewStreamService(kafka, redis, websocket, nats, config)
+	registry := health.NewRegistry()
+
+	registerHealthCheck(registry, "redis", redis, config)
+	registerHealthCheck(registry, "websocket", websocket, config)
+	registerHealthCheck(registry, "kafka", kafka, config)
+	registerHealthCheck(registry, "nats", nats, config)
+
+	if config.RestServiceURL != "" {
+		registerHealthCheck(registry, "rest", transport.NewRestClient(config.RestServiceURL), config)
+	}
+
+	streamService := service.NewStreamService(kafka, redis, websocket, nats, registry, config)
 	serviceHandler := handler.NewRestHandler(streamService, config)
 
 	router := gin.Default()
@@ -61,8 +89,18 @@ func main() {
 		c.JSON(http.StatusNotFound, gin.H{"message": "Method or route not found in: " + config.AppName})
 	})
 
-	router.GET("/live", serviceHandler.Live)
+	router.GET("/livez", serviceHandler.Live)
+	router.GET("/readyz", serviceHandler.Ready)
+	router.GET("/startupz", serviceHandler.Startup)
+	router.GET("/health", serviceHandler.Health)
+	router.GET("/metrics", serviceHandler.Metrics)
+	router.GET("/ws", serviceHandler.ServeWS)
+	router.GET("/sse", serviceHandler.ServeSSE)
 	router.GET("/read", serviceHandler.Read)
+	router.POST("/dlq/:topic/replay", serviceHandler.ReplayDeadLetters)
+	router.GET("/assets", serviceHandler.Assets)
+	router.POST("/assets/:asset", serviceHandler.AddAsset)
+	router.DELETE("/assets/:asset", serviceHandler.RemoveAsset)
 
 	// REST server
 	srv := &http.Server{
@@ -70,6 +108,10 @@ func main() {
 		Handler: router,
 	}
 
+	// Shutdown waits for active connections to go idle, which SSE streams
+	// never do and hijacked WebSocket connections are not tracked for.
+	srv.RegisterOnShutdown(streamService.CloseSubscribers)
+
 	go func() {
 		log.Printf("REST Server is starting on port:%s\n", config.GoServicePort)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -89,8 +131,22 @@ func main() {
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		log.Printf("Server forced to shutdown: %v", err)
+	}
+
+	if err := streamService.Shutdown(ctx); err != nil {
+		log.Printf("Stream service forced to shutdown: %v", err)
 	}
 
 	log.Println("Server exiting")
 }
+
+type healthRegistrant interface {
+	RegisterHealthCheck(registry *health.Registry, opts health.Options) error
+}
+
+func registerHealthCheck(registry *health.Registry, name string, r healthRegistrant, cnf *config.Config) {
+	if err := r.RegisterHealthCheck(registry, health.OptionsFromConfig(cnf.HealthCheck(name))); err != nil {
+		log.Fatalf("Fatal error registering %s health check: %v", name, err)
+	}
+}
@@ -1,8 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"log"
-	"os"
+	"sync"
 
 	"github.com/RackSec/srslog"
 	"github.com/go-playground/validator"
@@ -10,26 +11,96 @@ import (
 )
 
 type Config struct {
-	AppName            string `mapstructure:"APP_NAME" validate:"required"`
-	GoServicePort      string `mapstructure:"GO_SERVICE_PORT" validate:"required"`
-	SysLog             string `mapstructure:"SYSLOG" validate:"required"`
-	Https              string `mapstructure:"HTTPS" validate:"required"`
-	Logger             *srslog.Writer
-	Test               string   `mapstructure:"TEST" validate:"required"`
-	KafkaBrokers       []string `mapstructure:"KAFKA_BROKERS" validate:"required"`
-	KafkaConsumerGroup string   `mapstructure:"KAFKA_CONSUMER_GROUP" validate:"required"`
-	KafkaConsumeTopics []string `mapstructure:"KAFKA_CONSUME_TOPICS" validate:"required"`
-	KafkaProduceTopic  string   `mapstructure:"KAFKA_PRODUCE_TOPIC" validate:"required"`
-	RedisURL           string   `mapstructure:"REDIS_URL"`
-	WsServerURL        string   `mapstructure:"WSSERVER_URL"`
-	WsPingPeriod       int      `mapstructure:"WSPING_PERIOD"`
-	WsPinMaxError      int      `mapstructure:"WSPING_MAX_ERROR"`
-	NatsURL            []string `mapstructure:"NATS_URL" validate:"required"`
-	MaxRetry           int      `mapstructure:"MAX_RETRY"`
-	MaxWait            int      `mapstructure:"MAX_WAIT"`
+	AppName                 string `mapstructure:"APP_NAME" validate:"required"`
+	GoServicePort           string `mapstructure:"GO_SERVICE_PORT" validate:"required"`
+	SysLog                  string `mapstructure:"SYSLOG" validate:"required"`
+	Https                   string `mapstructure:"HTTPS" validate:"required"`
+	Logger                  *srslog.Writer
+	Test                    string                       `mapstructure:"TEST" validate:"required"`
+	KafkaBrokers            []string                     `mapstructure:"KAFKA_BROKERS" validate:"required"`
+	KafkaConsumerGroup      string                       `mapstructure:"KAFKA_CONSUMER_GROUP" validate:"required"`
+	KafkaConsumeTopics      []string                     `mapstructure:"KAFKA_CONSUME_TOPICS" validate:"required"`
+	KafkaProduceTopic       string                       `mapstructure:"KAFKA_PRODUCE_TOPIC" validate:"required"`
+	RedisURL                string                       `mapstructure:"REDIS_URL"`
+	RedisMode               string                       `mapstructure:"REDIS_MODE"`
+	RedisMasterName         string                       `mapstructure:"REDIS_MASTER_NAME"`
+	RedisAddrs              []string                     `mapstructure:"REDIS_ADDRS"`
+	RedisPassword           string                       `mapstructure:"REDIS_PASSWORD"`
+	RedisSentinelPassword   string                       `mapstructure:"REDIS_SENTINEL_PASSWORD"`
+	RedisDB                 int                          `mapstructure:"REDIS_DB"`
+	RedisCodec              string                       `mapstructure:"REDIS_CODEC"`
+	RedisStreams            []string                     `mapstructure:"REDIS_STREAMS"`
+	RedisStreamGroup        string                       `mapstructure:"REDIS_STREAM_GROUP"`
+	RedisStreamConsumer     string                       `mapstructure:"REDIS_STREAM_CONSUMER"`
+	RedisStreamProduce      string                       `mapstructure:"REDIS_STREAM_PRODUCE"`
+	RedisStreamMaxLen       int64                        `mapstructure:"REDIS_STREAM_MAXLEN"`
+	RedisStreamBlock        int                          `mapstructure:"REDIS_STREAM_BLOCK"`
+	RedisStreamClaimIdle    int                          `mapstructure:"REDIS_STREAM_CLAIM_IDLE"`
+	RedisStreamMaxDelivery  int64                        `mapstructure:"REDIS_STREAM_MAX_DELIVERIES"`
+	RedisPubSubPatterns     []string                     `mapstructure:"REDIS_PUBSUB_PATTERNS"`
+	RedisOpTimeout          int                          `mapstructure:"REDIS_OP_TIMEOUT"`
+	RedisWriteQueue         int                          `mapstructure:"REDIS_WRITE_QUEUE"`
+	WsServerURL             string                       `mapstructure:"WSSERVER_URL"`
+	WsPingPeriod            int                          `mapstructure:"WSPING_PERIOD"`
+	WsPongWait              int                          `mapstructure:"WSPONG_WAIT"`
+	WsPinMaxError           int                          `mapstructure:"WSPING_MAX_ERROR"`
+	WsSendQueue             int                          `mapstructure:"WS_SEND_QUEUE"`
+	WsSendPolicy            string                       `mapstructure:"WS_SEND_POLICY"`
+	WsHandshakeTimeout      int                          `mapstructure:"WS_HANDSHAKE_TIMEOUT"`
+	WsProxyURL              string                       `mapstructure:"WS_PROXY_URL"`
+	WsHeaders               map[string]string            `mapstructure:"WS_HEADERS"`
+	WsBearerToken           string                       `mapstructure:"WS_BEARER_TOKEN"`
+	WsAPIKey                string                       `mapstructure:"WS_API_KEY"`
+	WsAPIKeyHeader          string                       `mapstructure:"WS_API_KEY_HEADER"`
+	WsSubprotocols          []string                     `mapstructure:"WS_SUBPROTOCOLS"`
+	WsCompression           bool                         `mapstructure:"WS_COMPRESSION"`
+	WsTLSCAFile             string                       `mapstructure:"WS_TLS_CA_FILE"`
+	WsTLSCertFile           string                       `mapstructure:"WS_TLS_CERT_FILE"`
+	WsTLSKeyFile            string                       `mapstructure:"WS_TLS_KEY_FILE"`
+	WsTLSServerName         string                       `mapstructure:"WS_TLS_SERVER_NAME"`
+	WsTLSInsecureSkipVerify bool                         `mapstructure:"WS_TLS_INSECURE_SKIP_VERIFY"`
+	WsAssets                []string                     `mapstructure:"WS_ASSETS"`
+	WsSubscribeFrame        string                       `mapstructure:"WSSUBSCRIBE_FRAME"`
+	WsUnsubscribeFrame      string                       `mapstructure:"WSUNSUBSCRIBE_FRAME"`
+	WsAssetField            string                       `mapstructure:"WS_ASSET_FIELD"`
+	WsClientBuffer          int                          `mapstructure:"WS_CLIENT_BUFFER"`
+	WsAllowedOrigins        []string                     `mapstructure:"WS_ALLOWED_ORIGINS"`
+	SseBufferSize           int                          `mapstructure:"SSE_BUFFER_SIZE"`
+	SseHeartbeat            int                          `mapstructure:"SSE_HEARTBEAT"`
+	NatsURL                 []string                     `mapstructure:"NATS_URL" validate:"required"`
+	NatsSubjects            []string                     `mapstructure:"NATS_SUBJECTS"`
+	ReconnectInterval       int                          `mapstructure:"RECONNECT_INTERVAL"`
+	ReconnectMaxInterval    int                          `mapstructure:"RECONNECT_MAX_INTERVAL"`
+	ReconnectMultiplier     float64                      `mapstructure:"RECONNECT_MULTIPLIER"`
+	ReconnectJitter         float64                      `mapstructure:"RECONNECT_JITTER"`
+	ReconnectMaxAttempts    int                          `mapstructure:"RECONNECT_MAX_ATTEMPTS"`
+	KafkaHandlerRetries     int                          `mapstructure:"KAFKA_HANDLER_RETRIES"`
+	KafkaRetryBackoff       int                          `mapstructure:"KAFKA_RETRY_BACKOFF"`
+	KafkaDLQEnabled         bool                         `mapstructure:"KAFKA_DLQ_ENABLED"`
+	KafkaLagInterval        int                          `mapstructure:"KAFKA_LAG_INTERVAL"`
+	KafkaMaxLag             int64                        `mapstructure:"KAFKA_MAX_LAG"`
+	StreamHistorySize       int                          `mapstructure:"STREAM_HISTORY_SIZE"`
+	RestServiceURL          string                       `mapstructure:"REST_SERVICE_URL"`
+	LeaderElection          bool                         `mapstructure:"LEADER_ELECTION"`
+	LeaderKey               string                       `mapstructure:"LEADER_KEY"`
+	LeaderID                string                       `mapstructure:"LEADER_ID"`
+	LeaderTTL               int                          `mapstructure:"LEADER_TTL"`
+	LivenessTimeout         int                          `mapstructure:"LIVENESS_TIMEOUT"`
+	HealthChecks            map[string]HealthCheckConfig `mapstructure:"HEALTH_CHECKS"`
 }
 
-var config = &Config{}
+// HealthCheckConfig tunes a single dependency check, durations are in milliseconds.
+type HealthCheckConfig struct {
+	Interval int  `mapstructure:"INTERVAL"`
+	Timeout  int  `mapstructure:"TIMEOUT"`
+	Required bool `mapstructure:"REQUIRED"`
+}
+
+var (
+	config   = &Config{}
+	loadOnce sync.Once
+	loadErr  error
+)
 
 func init() {
 	viper.SetConfigName("config")
@@ -37,27 +108,56 @@ func init() {
 	viper.SetConfigType("json")
 
 	viper.SetDefault("REDIS_PORT", 6379)
-	viper.SetDefault("MAX_RETRY", 5)
-	viper.SetDefault("MAX_WAIT", 2000)
+	viper.SetDefault("RECONNECT_INTERVAL", 500)
+	viper.SetDefault("RECONNECT_MAX_INTERVAL", 30000)
+	viper.SetDefault("RECONNECT_MULTIPLIER", 2.0)
+	viper.SetDefault("RECONNECT_JITTER", 0.2)
+	viper.SetDefault("REDIS_OP_TIMEOUT", 2000)
+	viper.SetDefault("REDIS_WRITE_QUEUE", 1024)
+	viper.SetDefault("REDIS_MODE", "standalone")
+	viper.SetDefault("LEADER_KEY", "stream-ingest")
+	viper.SetDefault("LEADER_TTL", 15000)
+	viper.SetDefault("REDIS_STREAM_GROUP", "stream-service")
+	viper.SetDefault("REDIS_STREAM_MAXLEN", 10000)
+	viper.SetDefault("REDIS_STREAM_BLOCK", 2000)
+	viper.SetDefault("REDIS_STREAM_CLAIM_IDLE", 30000)
+	viper.SetDefault("REDIS_STREAM_MAX_DELIVERIES", 5)
+	viper.SetDefault("STREAM_HISTORY_SIZE", 100)
+	viper.SetDefault("WSPONG_WAIT", 30000)
+	viper.SetDefault("WS_SEND_QUEUE", 256)
+	viper.SetDefault("WS_SEND_POLICY", "block")
+	viper.SetDefault("WS_HANDSHAKE_TIMEOUT", 45000)
+	viper.SetDefault("WS_API_KEY_HEADER", "X-API-Key")
+	viper.SetDefault("WS_CLIENT_BUFFER", 256)
+	viper.SetDefault("SSE_BUFFER_SIZE", 1000)
+	viper.SetDefault("SSE_HEARTBEAT", 15000)
+	viper.SetDefault("KAFKA_HANDLER_RETRIES", 3)
+	viper.SetDefault("KAFKA_RETRY_BACKOFF", 500)
+	viper.SetDefault("KAFKA_LAG_INTERVAL", 10000)
+	viper.SetDefault("LIVENESS_TIMEOUT", 60000)
+}
 
+// load reads config.json, applies the defaults and validates the result. It
+// runs on the first GetConfig rather than at import, so that packages can be
+// imported, and tested, without a config file.
+func load() error {
 	log.Println("Reading config...")
 	err := viper.ReadInConfig()
 	if err != nil {
-		log.Fatalf("Fatal error config: %v", err)
+		return err
 	}
 
 	log.Println("Unmarshalling config...")
 	err = viper.Unmarshal(&config)
 	if err != nil {
-		log.Fatalf("Unable to decode into struct, %v", err)
+		return fmt.Errorf("unable to decode into struct, %v", err)
 	}
 
 	if config.SysLog == "true" {
 
 		config.Logger, err = srslog.Dial("", "", srslog.LOG_INFO, "CEF0")
 		if err != nil {
-			log.Println("Error setting up syslog:", err)
-			os.Exit(1)
+			return fmt.Errorf("error setting up syslog: %v", err)
 		}
 
 	}
@@ -65,10 +165,35 @@ func init() {
 	validate := validator.New()
 	err = validate.Struct(config)
 	if err != nil {
-		log.Fatalf("Config validation failed, %v", err)
+		return fmt.Errorf("config validation failed, %v", err)
+	}
+
+	return config.check()
+}
+
+// check rejects combinations of settings that the struct tags cannot
+// express.
+func (c *Config) check() error {
+	// A pong is only expected after a ping was sent, with a shorter wait
+	// every healthy peer would be declared dead.
+	if c.WsPongWait <= c.WsPingPeriod {
+		return fmt.Errorf("WSPONG_WAIT (%dms) must be longer than WSPING_PERIOD (%dms)", c.WsPongWait, c.WsPingPeriod)
+	}
+	return nil
+}
+
+// HealthCheck returns the check settings for the named dependency, falling
+// back to a required check polled every second.
+func (c *Config) HealthCheck(name string) HealthCheckConfig {
+	if hc, ok := c.HealthChecks[name]; ok {
+		return hc
 	}
+	return HealthCheckConfig{Interval: 1000, Timeout: 1000, Required: true}
 }
 
 func GetConfig() (*Config, error) {
-	return config, nil
+	loadOnce.Do(func() {
+		loadErr = load()
+	})
+	return config, loadErr
 }
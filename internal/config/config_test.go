@@ -0,0 +1,25 @@
+package config
+
+import "testing"
+
+func TestCheckPongWait(t *testing.T) {
+	tests := []struct {
+		name       string
+		pingPeriod int
+		pongWait   int
+		wantErr    bool
+	}{
+		{"pong wait longer than the ping period", 10000, 30000, false},
+		{"pong wait equal to the ping period", 10000, 10000, true},
+		{"pong wait shorter than the ping period", 30000, 10000, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{WsPingPeriod: tt.pingPeriod, WsPongWait: tt.pongWait}
+			if err := c.check(); (err != nil) != tt.wantErr {
+				t.Errorf("check() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,7 +1,12 @@
 package handler
 
 import (
+	"errors"
+	"net/http"
+	"strconv"
+
 	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/config"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/metrics"
 	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/service"
 	"github.com/gin-gonic/gin"
 )
@@ -26,9 +31,14 @@ func (s *RestHandler) Live(c *gin.Context) {
 	c.JSON(statusCode, gin.H{"status": ok})
 }
 
-func (s *RestHandler) Read(c *gin.Context) {
+func (s *RestHandler) Ready(c *gin.Context) {
 
-	statusCode, ok, err := s.StreamService.Live(c.Request.Context())
+	if verbose(c) {
+		s.Health(c)
+		return
+	}
+
+	statusCode, ok, err := s.StreamService.Ready(c.Request.Context())
 	if err != nil {
 		c.JSON(statusCode, gin.H{"error": err.Error()})
 		return
@@ -36,3 +46,121 @@ func (s *RestHandler) Read(c *gin.Context) {
 
 	c.JSON(statusCode, gin.H{"status": ok})
 }
+
+func (s *RestHandler) Health(c *gin.Context) {
+
+	statusCode, report := s.StreamService.Health(c.Request.Context())
+	c.JSON(statusCode, report)
+}
+
+func (s *RestHandler) Startup(c *gin.Context) {
+
+	statusCode, ok, err := s.StreamService.Startup(c.Request.Context())
+	if err != nil {
+		c.JSON(statusCode, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(statusCode, gin.H{"status": ok})
+}
+
+func (s *RestHandler) Read(c *gin.Context) {
+
+	start, err := strconv.ParseInt(c.DefaultQuery("start", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start must be an integer"})
+		return
+	}
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(service.DefaultReadLimit)), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be an integer"})
+		return
+	}
+
+	query := service.ReadQuery{
+		Key:     c.Query("key"),
+		Prefix:  c.Query("prefix"),
+		History: c.Query("history") == "1" || c.Query("history") == "true",
+		Start:   start,
+		Limit:   limit,
+	}
+
+	statusCode, result, err := s.StreamService.Read(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(statusCode, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(statusCode, result)
+}
+
+func (s *RestHandler) ReplayDeadLetters(c *gin.Context) {
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+		return
+	}
+
+	replayed, err := s.StreamService.ReplayDeadLetters(c.Request.Context(), c.Param("topic"), limit)
+	if errors.Is(err, service.ErrUnknownTopic) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "replayed": replayed})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"replayed": replayed})
+}
+
+func (s *RestHandler) Assets(c *gin.Context) {
+
+	c.JSON(http.StatusOK, gin.H{"assets": s.StreamService.Assets(c.Request.Context())})
+}
+
+func (s *RestHandler) AddAsset(c *gin.Context) {
+
+	err := s.StreamService.AddAsset(c.Request.Context(), c.Param("asset"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"assets": s.StreamService.Assets(c.Request.Context())})
+}
+
+func (s *RestHandler) RemoveAsset(c *gin.Context) {
+
+	err := s.StreamService.RemoveAsset(c.Request.Context(), c.Param("asset"))
+	if errors.Is(err, service.ErrUnknownAsset) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"assets": s.StreamService.Assets(c.Request.Context())})
+}
+
+func (s *RestHandler) Metrics(c *gin.Context) {
+
+	c.Header("Content-Type", metrics.ContentType)
+	c.Status(http.StatusOK)
+
+	if err := s.StreamService.WriteMetrics(c.Writer); err != nil {
+		c.Error(err)
+	}
+}
+
+func verbose(c *gin.Context) bool {
+	switch c.Query("verbose") {
+	case "1", "true":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/hub"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/utils"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// ServeSSE streams the same channel data as /ws as Server-Sent Events. A
+// client reconnecting with Last-Event-ID first receives the buffered events
+// it missed.
+func (s *RestHandler) ServeSSE(c *gin.Context) {
+
+	lastID := int64(0)
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID must be an integer"})
+			return
+		}
+		lastID = id
+	}
+
+	channels := utils.SplitString(c.Query("channels"), ",")
+
+	// Subscribe before reading the backlog so that nothing published in
+	// between is lost, duplicates are skipped by id below.
+	sub := s.StreamService.Subscribe(channels...)
+	defer s.StreamService.Unsubscribe(sub)
+
+	var backlog []*hub.Message
+	if lastID > 0 {
+		var err error
+		backlog, err = s.StreamService.RecentEvents(c.Request.Context(), channels, lastID)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	for _, event := range backlog {
+		if err := writeEvent(c, event); err != nil {
+			return
+		}
+		lastID = event.ID
+	}
+	c.Writer.Flush()
+
+	// SSE_HEARTBEAT <= 0 disables the heartbeat, a nil channel never fires.
+	var heartbeat <-chan time.Time
+	if s.Config.SseHeartbeat > 0 {
+		ticker := time.NewTicker(time.Duration(s.Config.SseHeartbeat) * time.Millisecond)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-sub.Done():
+			return
+		case event := <-sub.Messages():
+			if event.ID != 0 && event.ID <= lastID {
+				continue
+			}
+			if err := writeEvent(c, event); err != nil {
+				log.Printf("SSE client write failed: %v", err)
+				return
+			}
+		case <-heartbeat:
+			if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+
+		c.Writer.Flush()
+	}
+}
+
+func writeEvent(c *gin.Context, event *hub.Message) error {
+	e := sse.Event{Data: event}
+	if event.ID != 0 {
+		e.Id = strconv.FormatInt(event.ID, 10)
+	}
+	return sse.Encode(c.Writer, e)
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/hub"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	clientWriteWait      = 10 * time.Second
+	clientPongWait       = 60 * time.Second
+	clientPingPeriod     = (clientPongWait * 9) / 10
+	clientMaxMessageSize = 4096
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsRequest is sent by downstream clients to change their channels, e.g.
+// {"action": "subscribe", "channels": ["BTCUSDT", "topic1"]}.
+type wsRequest struct {
+	Action   string   `json:"action"`
+	Channels []string `json:"channels"`
+}
+
+// ServeWS upgrades the request and streams the messages of the channels the
+// client joined, initially those in the comma separated channels query.
+func (s *RestHandler) ServeWS(c *gin.Context) {
+
+	up := upgrader
+	up.CheckOrigin = s.checkOrigin
+
+	conn, err := up.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade websocket client: %v", err)
+		return
+	}
+
+	sub := s.StreamService.Subscribe(utils.SplitString(c.Query("channels"), ",")...)
+
+	go clientWritePump(conn, sub)
+	s.clientReadPump(conn, sub)
+}
+
+// checkOrigin accepts non-browser clients, which send no Origin header.
+// Browsers always send one, and it must be listed in WS_ALLOWED_ORIGINS ("*"
+// allows any); without an allowlist only same-origin pages may connect.
+func (s *RestHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if len(s.Config.WsAllowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+
+	for _, allowed := range s.Config.WsAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *RestHandler) clientReadPump(conn *websocket.Conn, sub *hub.Subscriber) {
+	defer s.StreamService.Unsubscribe(sub)
+
+	conn.SetReadLimit(clientMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(clientPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(clientPongWait))
+	})
+
+	for {
+		var req wsRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("WebSocket client read failed: %v", err)
+			}
+			return
+		}
+
+		switch req.Action {
+		case "subscribe":
+			sub.Join(req.Channels...)
+		case "unsubscribe":
+			sub.Leave(req.Channels...)
+		}
+	}
+}
+
+// clientWritePump is the only writer of conn. It closes the connection once
+// the subscriber is removed, telling slow consumers why.
+func clientWritePump(conn *websocket.Conn, sub *hub.Subscriber) {
+	ticker := time.NewTicker(clientPingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case msg := <-sub.Messages():
+			conn.SetWriteDeadline(time.Now().Add(clientWriteWait))
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(clientWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-sub.Done():
+			code, reason := websocket.CloseNormalClosure, ""
+			switch {
+			case errors.Is(sub.Err(), hub.ErrSlowConsumer):
+				code, reason = websocket.ClosePolicyViolation, "slow consumer"
+			case errors.Is(sub.Err(), hub.ErrHubClosed):
+				code, reason = websocket.CloseGoingAway, "server shutting down"
+			}
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(clientWriteWait))
+			return
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/config"
+)
+
+const (
+	DefaultInterval = time.Second
+	DefaultTimeout  = time.Second
+)
+
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a plain function to the Checker interface.
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+type Criticality int
+
+const (
+	// Required checks gate readiness and startup.
+	Required Criticality = iota
+	// Optional checks are reported but never make the pod unready.
+	Optional
+)
+
+func (c Criticality) String() string {
+	if c == Optional {
+		return "optional"
+	}
+	return "required"
+}
+
+type Options struct {
+	Interval    time.Duration
+	Timeout     time.Duration
+	Criticality Criticality
+}
+
+// OptionsFromConfig converts the millisecond based settings of
+// HEALTH_CHECKS into check options.
+func OptionsFromConfig(hc config.HealthCheckConfig) Options {
+	opts := Options{
+		Interval:    time.Duration(hc.Interval) * time.Millisecond,
+		Timeout:     time.Duration(hc.Timeout) * time.Millisecond,
+		Criticality: Required,
+	}
+	if !hc.Required {
+		opts.Criticality = Optional
+	}
+	return opts
+}
+
+type Check struct {
+	Name    string
+	Checker Checker
+	Options
+}
+
+type entry struct {
+	check               Check
+	checked             bool
+	healthy             bool
+	passed              bool
+	err                 error
+	lastChecked         time.Time
+	lastSuccess         time.Time
+	lastFailure         time.Time
+	consecutiveFailures int
+	latency             time.Duration
+}
+
+type Registry struct {
+	mu      sync.RWMutex
+	entries map[string]*entry
+	ctx     context.Context
+}
+
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[string]*entry)}
+}
+
+// Register adds a named check. Checks registered after Start begin polling
+// immediately.
+func (r *Registry) Register(check Check) error {
+	if check.Name == "" || check.Checker == nil {
+		return fmt.Errorf("health check requires a name and a checker")
+	}
+	if check.Interval <= 0 {
+		check.Interval = DefaultInterval
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = DefaultTimeout
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.entries[check.Name]; ok {
+		return fmt.Errorf("health check %q already registered", check.Name)
+	}
+
+	e := &entry{check: check}
+	r.entries[check.Name] = e
+
+	if r.ctx != nil {
+		go r.run(r.ctx, e)
+	}
+
+	return nil
+}
+
+func (r *Registry) Start(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ctx != nil {
+		return
+	}
+	r.ctx = ctx
+
+	for _, e := range r.entries {
+		go r.run(ctx, e)
+	}
+}
+
+func (r *Registry) run(ctx context.Context, e *entry) {
+	ticker := time.NewTicker(e.check.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.runOnce(ctx, e)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Registry) runOnce(ctx context.Context, e *entry) {
+	checkCtx, cancel := context.WithTimeout(ctx, e.check.Timeout)
+	defer cancel()
+
+	begin := time.Now()
+	err := e.check.Checker.Check(checkCtx)
+	latency := time.Since(begin)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	healthy := err == nil
+	if !e.checked || healthy != e.healthy {
+		log.Printf("Health check %s (%s) healthy: %v %s", e.check.Name, e.check.Criticality, healthy, errorText(err))
+	}
+
+	e.checked = true
+	e.healthy = healthy
+	e.lastChecked = begin
+	e.latency = latency
+	if healthy {
+		e.passed = true
+		e.lastSuccess = begin
+		e.consecutiveFailures = 0
+	} else {
+		e.err = err
+		e.lastFailure = begin
+		e.consecutiveFailures++
+	}
+}
+
+// Ready reports whether every required check is currently passing and lists
+// the ones that are not.
+func (r *Registry) Ready() (bool, []string) {
+	return r.collect(func(e *entry) bool { return e.healthy })
+}
+
+// Started reports whether every required check has passed at least once.
+func (r *Registry) Started() (bool, []string) {
+	return r.collect(func(e *entry) bool { return e.passed })
+}
+
+func (r *Registry) collect(ok func(e *entry) bool) (bool, []string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var failing []string
+	for name, e := range r.entries {
+		if e.check.Criticality == Required && !ok(e) {
+			failing = append(failing, name)
+		}
+	}
+	sort.Strings(failing)
+
+	return len(failing) == 0, failing
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,180 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flaky fails while err is set.
+type flaky struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (f *flaky) Check(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.err
+}
+
+func (f *flaky) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.err = err
+}
+
+func (f *flaky) HealthDetails() map[string]interface{} {
+	return map[string]interface{}{"url": "redis://localhost"}
+}
+
+// checkNow runs the named check once, bypassing the polling interval.
+func checkNow(t *testing.T, r *Registry, name string) {
+	t.Helper()
+
+	r.mu.RLock()
+	e, ok := r.entries[name]
+	r.mu.RUnlock()
+	if !ok {
+		t.Fatalf("check %s is not registered", name)
+	}
+	r.runOnce(context.Background(), e)
+}
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry()
+	ok := CheckerFunc(func(ctx context.Context) error { return nil })
+
+	tests := []struct {
+		name    string
+		check   Check
+		wantErr bool
+	}{
+		{"valid", Check{Name: "redis", Checker: ok}, false},
+		{"duplicate", Check{Name: "redis", Checker: ok}, true},
+		{"no name", Check{Checker: ok}, true},
+		{"no checker", Check{Name: "kafka"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := r.Register(tt.check); (err != nil) != tt.wantErr {
+				t.Errorf("Register() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	e := r.entries["redis"]
+	if e.check.Interval != DefaultInterval || e.check.Timeout != DefaultTimeout {
+		t.Errorf("defaults = %v/%v, want %v/%v", e.check.Interval, e.check.Timeout, DefaultInterval, DefaultTimeout)
+	}
+}
+
+func TestReadyAndStarted(t *testing.T) {
+	r := NewRegistry()
+	redis, rest := &flaky{}, &flaky{}
+	r.Register(Check{Name: "redis", Checker: redis})
+	r.Register(Check{Name: "rest", Checker: rest, Options: Options{Criticality: Optional}})
+
+	if ok, failing := r.Started(); ok || !reflect.DeepEqual(failing, []string{"redis"}) {
+		t.Errorf("Started() before any check = %v %v, want false [redis]", ok, failing)
+	}
+
+	rest.fail(errors.New("connection refused"))
+	checkNow(t, r, "redis")
+	checkNow(t, r, "rest")
+
+	if ok, failing := r.Ready(); !ok {
+		t.Errorf("Ready() with only an optional check failing = false %v, want true", failing)
+	}
+
+	redis.fail(errors.New("connection refused"))
+	checkNow(t, r, "redis")
+
+	if ok, failing := r.Ready(); ok || !reflect.DeepEqual(failing, []string{"redis"}) {
+		t.Errorf("Ready() with redis failing = %v %v, want false [redis]", ok, failing)
+	}
+	if ok, _ := r.Started(); !ok {
+		t.Error("Started() turned false after a passed check failed")
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := NewRegistry()
+	redis := &flaky{}
+	r.Register(Check{Name: "redis", Checker: redis})
+
+	if got := r.Report(); got.Status != StatusDown || got.Checks["redis"].Status != StatusUnknown {
+		t.Errorf("report before any check = %s/%s, want down/unknown", got.Status, got.Checks["redis"].Status)
+	}
+
+	redis.fail(errors.New("connection refused"))
+	checkNow(t, r, "redis")
+	checkNow(t, r, "redis")
+
+	report := r.Report()
+	cr := report.Checks["redis"]
+	if report.Status != StatusDown || cr.Status != StatusDown {
+		t.Errorf("report while failing = %s/%s, want down/down", report.Status, cr.Status)
+	}
+	if cr.ConsecutiveFailures != 2 || cr.LastError != "connection refused" || cr.LastFailure == nil {
+		t.Errorf("failing check = %+v, want 2 failures with the last error", cr)
+	}
+	if cr.Details["url"] != "redis://localhost" {
+		t.Errorf("details = %v, want the checker details", cr.Details)
+	}
+
+	redis.fail(nil)
+	checkNow(t, r, "redis")
+
+	report = r.Report()
+	cr = report.Checks["redis"]
+	if report.Status != StatusUp || cr.Status != StatusUp || cr.ConsecutiveFailures != 0 || cr.LastSuccess == nil {
+		t.Errorf("report after recovery = %s, %+v, want up with no failures", report.Status, cr)
+	}
+	if cr.LastError != "connection refused" {
+		t.Errorf("last error = %q, want it kept after recovery", cr.LastError)
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	r := NewRegistry()
+	r.Register(Check{Name: "slow", Checker: CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}), Options: Options{Timeout: 10 * time.Millisecond}})
+
+	checkNow(t, r, "slow")
+
+	if ok, _ := r.Ready(); ok {
+		t.Error("Ready() = true with a check that timed out")
+	}
+}
+
+func TestStartPollsChecks(t *testing.T) {
+	r := NewRegistry()
+	r.Register(Check{Name: "before", Checker: &flaky{}, Options: Options{Interval: 10 * time.Millisecond}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.Start(ctx)
+
+	r.Register(Check{Name: "after", Checker: &flaky{}, Options: Options{Interval: 10 * time.Millisecond}})
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if ok, _ := r.Started(); ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			_, failing := r.Started()
+			t.Fatalf("checks never ran after Start: %v", failing)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
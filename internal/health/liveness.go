@@ -0,0 +1,86 @@
+package health
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Liveness tracks the progress of the long-running loops of the process.
+// Every loop beats while it works; a loop that stays silent longer than its
+// timeout is reported as stalled, which is what a deadlock looks like from
+// the outside.
+type Liveness struct {
+	mu    sync.RWMutex
+	beats map[string]*Beat
+}
+
+// Beat is the progress handle of a single loop. A nil Beat ignores calls,
+// so loops can beat unconditionally.
+type Beat struct {
+	timeout time.Duration
+	last    int64
+	paused  int32
+}
+
+func NewLiveness() *Liveness {
+	return &Liveness{beats: make(map[string]*Beat)}
+}
+
+// Register adds a loop that must beat at least every timeout. The loop
+// counts as active from now on.
+func (l *Liveness) Register(name string, timeout time.Duration) *Beat {
+	b := &Beat{timeout: timeout}
+	b.Beat()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.beats[name] = b
+	return b
+}
+
+// Stalled lists the active loops that missed their timeout, with how long
+// they have been silent.
+func (l *Liveness) Stalled() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var stalled []string
+	for name, b := range l.beats {
+		if silent, ok := b.stalled(); ok {
+			stalled = append(stalled, fmt.Sprintf("%s (%v)", name, silent.Round(time.Second)))
+		}
+	}
+	sort.Strings(stalled)
+
+	return stalled
+}
+
+// Beat records progress and resumes a paused loop.
+func (b *Beat) Beat() {
+	if b == nil {
+		return
+	}
+	atomic.StoreInt64(&b.last, time.Now().UnixNano())
+	atomic.StoreInt32(&b.paused, 0)
+}
+
+// Pause exempts the loop until its next beat, for waits that are not a
+// stall, e.g. while a dependency is down.
+func (b *Beat) Pause() {
+	if b == nil {
+		return
+	}
+	atomic.StoreInt32(&b.paused, 1)
+}
+
+func (b *Beat) stalled() (time.Duration, bool) {
+	if atomic.LoadInt32(&b.paused) == 1 {
+		return 0, false
+	}
+	silent := time.Since(time.Unix(0, atomic.LoadInt64(&b.last)))
+	return silent, silent > b.timeout
+}
@@ -0,0 +1,91 @@
+package health
+
+import (
+	"time"
+)
+
+const (
+	StatusUp      = "up"
+	StatusDown    = "down"
+	StatusUnknown = "unknown"
+)
+
+// Report is the JSON document served by /health and /readyz?verbose=1.
+// Field names are part of the public contract, see README.md.
+type Report struct {
+	Status    string                 `json:"status"`
+	Timestamp time.Time              `json:"timestamp"`
+	Checks    map[string]CheckReport `json:"checks"`
+}
+
+type CheckReport struct {
+	Status              string                 `json:"status"`
+	Criticality         string                 `json:"criticality"`
+	LastChecked         *time.Time             `json:"last_checked"`
+	LastSuccess         *time.Time             `json:"last_success"`
+	LastFailure         *time.Time             `json:"last_failure"`
+	LastError           string                 `json:"last_error"`
+	ConsecutiveFailures int                    `json:"consecutive_failures"`
+	LatencyMs           float64                `json:"latency_ms"`
+	Details             map[string]interface{} `json:"details,omitempty"`
+}
+
+// DetailsProvider is implemented by checkers that expose extra,
+// dependency-specific fields in the report. It is called while building the
+// report and must not block.
+type DetailsProvider interface {
+	HealthDetails() map[string]interface{}
+}
+
+// Report snapshots every registered check. The overall status is down as
+// soon as a required check is not passing.
+func (r *Registry) Report() Report {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	report := Report{
+		Status:    StatusUp,
+		Timestamp: time.Now().UTC(),
+		Checks:    make(map[string]CheckReport, len(r.entries)),
+	}
+
+	for name, e := range r.entries {
+		cr := CheckReport{
+			Status:              StatusUnknown,
+			Criticality:         e.check.Criticality.String(),
+			LastChecked:         timePtr(e.lastChecked),
+			LastSuccess:         timePtr(e.lastSuccess),
+			LastFailure:         timePtr(e.lastFailure),
+			LastError:           errorText(e.err),
+			ConsecutiveFailures: e.consecutiveFailures,
+			LatencyMs:           float64(e.latency.Microseconds()) / 1000,
+		}
+
+		if dp, ok := e.check.Checker.(DetailsProvider); ok {
+			cr.Details = dp.HealthDetails()
+		}
+
+		if e.checked {
+			cr.Status = StatusDown
+			if e.healthy {
+				cr.Status = StatusUp
+			}
+		}
+
+		if e.check.Criticality == Required && cr.Status != StatusUp {
+			report.Status = StatusDown
+		}
+
+		report.Checks[name] = cr
+	}
+
+	return report
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
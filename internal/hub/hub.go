@@ -0,0 +1,188 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+const DefaultBufferSize = 256
+
+var (
+	ErrSlowConsumer = errors.New("subscriber could not keep up")
+	ErrHubClosed    = errors.New("hub closed")
+)
+
+// Message is what downstream clients receive, Data is always valid JSON.
+type Message struct {
+	ID        int64           `json:"id,omitempty"`
+	Channel   string          `json:"channel"`
+	Source    string          `json:"source"`
+	Data      json.RawMessage `json:"data"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// Hub fans messages out to the subscribers joined to their channel. A
+// subscriber whose buffer is full is disconnected rather than slowing down
+// the pipelines publishing into the hub.
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscriber]struct{}
+	bufferSize  int
+	closed      bool
+}
+
+type Subscriber struct {
+	mu       sync.RWMutex
+	channels map[string]struct{}
+	send     chan *Message
+	done     chan struct{}
+	once     sync.Once
+	err      error
+}
+
+func NewHub(bufferSize int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	return &Hub{
+		subscribers: make(map[*Subscriber]struct{}),
+		bufferSize:  bufferSize,
+	}
+}
+
+func (h *Hub) Subscribe(channels ...string) *Subscriber {
+	sub := &Subscriber{
+		channels: make(map[string]struct{}),
+		send:     make(chan *Message, h.bufferSize),
+		done:     make(chan struct{}),
+	}
+	sub.Join(channels...)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		sub.close(ErrHubClosed)
+		return sub
+	}
+	h.subscribers[sub] = struct{}{}
+
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscriber) {
+	h.remove(sub, nil)
+}
+
+func (h *Hub) remove(sub *Subscriber, err error) {
+	h.mu.Lock()
+	delete(h.subscribers, sub)
+	h.mu.Unlock()
+
+	sub.close(err)
+}
+
+// Publish never blocks, slow subscribers are dropped instead.
+func (h *Hub) Publish(msg *Message) {
+	var slow []*Subscriber
+
+	h.mu.RLock()
+	for sub := range h.subscribers {
+		if !sub.Joined(msg.Channel) {
+			continue
+		}
+
+		select {
+		case sub.send <- msg:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, sub := range slow {
+		h.remove(sub, ErrSlowConsumer)
+	}
+}
+
+// Close removes every subscriber with ErrHubClosed, so that the handlers
+// streaming to them return, and closes the subscribers created afterwards
+// right away.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	subscribers := h.subscribers
+	h.subscribers = make(map[*Subscriber]struct{})
+	h.mu.Unlock()
+
+	for sub := range subscribers {
+		sub.close(ErrHubClosed)
+	}
+}
+
+func (h *Hub) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.subscribers)
+}
+
+func (s *Subscriber) Join(channels ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range channels {
+		if c != "" {
+			s.channels[c] = struct{}{}
+		}
+	}
+}
+
+func (s *Subscriber) Leave(channels ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range channels {
+		delete(s.channels, c)
+	}
+}
+
+func (s *Subscriber) Joined(channel string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.channels[channel]
+	return ok
+}
+
+func (s *Subscriber) Messages() <-chan *Message {
+	return s.send
+}
+
+// Done is closed once the subscriber has been removed from the hub, either
+// explicitly, because it could not keep up or because the hub was closed.
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err tells why the subscriber was removed, it is nil for an explicit
+// Unsubscribe and only meaningful once Done is closed.
+func (s *Subscriber) Err() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.err
+}
+
+func (s *Subscriber) close(err error) {
+	s.once.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+
+		close(s.done)
+	})
+}
@@ -0,0 +1,155 @@
+package hub
+
+import (
+	"testing"
+)
+
+func receive(t *testing.T, sub *Subscriber) []string {
+	t.Helper()
+
+	var channels []string
+	for {
+		select {
+		case msg := <-sub.Messages():
+			channels = append(channels, msg.Channel)
+		default:
+			return channels
+		}
+	}
+}
+
+func TestPublishRoutesByChannel(t *testing.T) {
+	h := NewHub(8)
+	btc := h.Subscribe("BTCUSDT")
+	both := h.Subscribe("BTCUSDT", "topic1")
+	none := h.Subscribe()
+
+	for _, channel := range []string{"BTCUSDT", "topic1", "ETHUSDT"} {
+		h.Publish(&Message{Channel: channel})
+	}
+
+	tests := []struct {
+		name string
+		sub  *Subscriber
+		want []string
+	}{
+		{"one channel", btc, []string{"BTCUSDT"}},
+		{"two channels", both, []string{"BTCUSDT", "topic1"}},
+		{"no channels", none, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := receive(t, tt.sub)
+			if len(got) != len(tt.want) {
+				t.Fatalf("received %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("received %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestJoinAndLeave(t *testing.T) {
+	h := NewHub(8)
+	sub := h.Subscribe("BTCUSDT", "")
+
+	sub.Join("ETHUSDT")
+	sub.Leave("BTCUSDT")
+
+	h.Publish(&Message{Channel: "BTCUSDT"})
+	h.Publish(&Message{Channel: "ETHUSDT"})
+	h.Publish(&Message{Channel: ""})
+
+	if got := receive(t, sub); len(got) != 1 || got[0] != "ETHUSDT" {
+		t.Errorf("received %v, want [ETHUSDT]", got)
+	}
+}
+
+func TestSlowConsumerIsDropped(t *testing.T) {
+	h := NewHub(2)
+	slow := h.Subscribe("BTCUSDT")
+	fast := h.Subscribe("BTCUSDT")
+
+	for i := 0; i < 3; i++ {
+		h.Publish(&Message{Channel: "BTCUSDT"})
+		receive(t, fast)
+	}
+
+	select {
+	case <-slow.Done():
+	default:
+		t.Fatal("slow subscriber was not removed once its buffer was full")
+	}
+	if slow.Err() != ErrSlowConsumer {
+		t.Errorf("Err() = %v, want ErrSlowConsumer", slow.Err())
+	}
+
+	select {
+	case <-fast.Done():
+		t.Fatal("subscriber that kept up was removed")
+	default:
+	}
+	if h.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", h.Len())
+	}
+
+	// Publishing after the removal must not block on the stale buffer.
+	h.Publish(&Message{Channel: "BTCUSDT"})
+}
+
+func TestUnsubscribe(t *testing.T) {
+	h := NewHub(0)
+	sub := h.Subscribe("BTCUSDT")
+
+	h.Unsubscribe(sub)
+	h.Unsubscribe(sub)
+
+	select {
+	case <-sub.Done():
+	default:
+		t.Fatal("Done() not closed after Unsubscribe")
+	}
+	if sub.Err() != nil {
+		t.Errorf("Err() = %v, want nil for an explicit Unsubscribe", sub.Err())
+	}
+	if h.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", h.Len())
+	}
+	if cap(sub.send) != DefaultBufferSize {
+		t.Errorf("buffer = %d, want DefaultBufferSize", cap(sub.send))
+	}
+}
+
+func TestCloseRemovesSubscribers(t *testing.T) {
+	h := NewHub(8)
+	sub := h.Subscribe("BTCUSDT")
+
+	h.Close()
+
+	select {
+	case <-sub.Done():
+	default:
+		t.Fatal("subscriber not closed")
+	}
+	if sub.Err() != ErrHubClosed {
+		t.Errorf("Err() = %v, want %v", sub.Err(), ErrHubClosed)
+	}
+	if h.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", h.Len())
+	}
+
+	late := h.Subscribe("BTCUSDT")
+	select {
+	case <-late.Done():
+	default:
+		t.Fatal("subscriber created after Close not closed")
+	}
+	h.Publish(&Message{Channel: "BTCUSDT"})
+	if got := receive(t, late); len(got) != 0 {
+		t.Errorf("late subscriber received %v", got)
+	}
+}
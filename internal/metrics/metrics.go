@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// Collector returns the current samples of a metric, it is called on every
+// scrape.
+type Collector func() []Sample
+
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	collect Collector
+}
+
+// Registry renders registered metrics in the Prometheus text exposition
+// format.
+type Registry struct {
+	mu      sync.RWMutex
+	metrics map[string]metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+func (r *Registry) Gauge(name, help string, collect Collector) {
+	r.register(metric{name: name, help: help, kind: "gauge", collect: collect})
+}
+
+func (r *Registry) Counter(name, help string, collect Collector) {
+	r.register(metric{name: name, help: help, kind: "counter", collect: collect})
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics[m.name] = m
+}
+
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		r.mu.RLock()
+		m := r.metrics[name]
+		r.mu.RUnlock()
+
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind); err != nil {
+			return err
+		}
+
+		for _, sample := range m.collect() {
+			if _, err := fmt.Fprintf(w, "%s%s %v\n", m.name, formatLabels(sample.Labels), sample.Value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"strings"
+)
+
+// Assets lists the upstream WebSocket subscriptions, they are replayed after
+// every reconnect.
+func (s *streamService) Assets(ctx context.Context) []string {
+	return s.webSocket.Subscriptions()
+}
+
+func (s *streamService) AddAsset(ctx context.Context, asset string) error {
+	asset = strings.TrimSpace(asset)
+	if asset == "" {
+		return ErrInvalidAsset
+	}
+
+	s.webSocket.Subscribe(asset)
+	return nil
+}
+
+func (s *streamService) RemoveAsset(ctx context.Context, asset string) error {
+	if !s.webSocket.Unsubscribe(strings.TrimSpace(asset)) {
+		return ErrUnknownAsset
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/hub"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/transport"
+)
+
+// Subscribe registers a downstream client on the fan-out hub. Channels are
+// Kafka topics, NATS subjects and upstream asset symbols.
+func (s *streamService) Subscribe(channels ...string) *hub.Subscriber {
+	return s.hub.Subscribe(channels...)
+}
+
+func (s *streamService) Unsubscribe(sub *hub.Subscriber) {
+	s.hub.Unsubscribe(sub)
+}
+
+// CloseSubscribers disconnects every downstream client. The HTTP server
+// calls it on shutdown, it would otherwise wait for the SSE and WebSocket
+// streams, which never go idle.
+func (s *streamService) CloseSubscribers() {
+	s.hub.Close()
+}
+
+// fanOut publishes msg to the local subscribers right away and records it
+// for SSE resume in the background, so that neither a slow nor an
+// unavailable Redis ever stalls the pipeline that delivered it.
+func (s *streamService) fanOut(ctx context.Context, msg *transport.Message) error {
+	s.publishEvent(msg, true)
+	return nil
+}
+
+// publishEvent hands msg to the local subscribers and, when record is set,
+// queues it for the SSE resume buffer.
+func (s *streamService) publishEvent(msg *transport.Message, record bool) {
+	event := &hub.Message{
+		ID:        s.nextEventID(),
+		Channel:   msg.Topic,
+		Source:    msg.Source,
+		Data:      jsonValue(msg.Value),
+		Timestamp: msg.Timestamp.UTC(),
+	}
+
+	s.hub.Publish(event)
+
+	if !record {
+		return
+	}
+
+	// A full queue drops the record, counted in
+	// background_writes_dropped_total; the live event is unaffected.
+	s.events.Enqueue(func(ctx context.Context) error { return s.recordEvent(ctx, event) })
+}
+
+// nextEventID derives increasing ids from the clock in microseconds, so they
+// stay ordered across restarts and roughly across replicas without a round
+// trip to Redis.
+func (s *streamService) nextEventID() int64 {
+	for {
+		last := atomic.LoadInt64(&s.lastEvent)
+		id := time.Now().UnixMicro()
+		if id <= last {
+			id = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&s.lastEvent, last, id) {
+			return id
+		}
+	}
+}
+
+// recordEvent keeps the event in the bounded per-channel buffer that SSE
+// clients resume from.
+func (s *streamService) recordEvent(ctx context.Context, event *hub.Message) error {
+	key := eventsKey(event.Channel)
+	if err := s.redis.PushList(ctx, key, event); err != nil {
+		return err
+	}
+
+	return s.redis.TrimList(ctx, key, 0, int64(s.config.SseBufferSize-1))
+}
+
+// RecentEvents returns the buffered events of channels newer than afterID,
+// oldest first.
+func (s *streamService) RecentEvents(ctx context.Context, channels []string, afterID int64) ([]*hub.Message, error) {
+	var events []*hub.Message
+
+	for _, channel := range channels {
+		if channel == "" {
+			continue
+		}
+
+		buffered, err := transport.GetListAs[*hub.Message](ctx, s.redis, eventsKey(channel), 0, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, event := range buffered {
+			if event.ID > afterID {
+				events = append(events, event)
+			}
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+	return events, nil
+}
+
+func eventsKey(channel string) string {
+	return "events:" + channel
+}
+
+// jsonValue keeps JSON payloads as-is and wraps anything else in a JSON
+// string.
+func jsonValue(value []byte) json.RawMessage {
+	if json.Valid(value) {
+		return json.RawMessage(value)
+	}
+
+	data, _ := json.Marshal(string(value))
+	return data
+}
+
+// assetOf reads the asset symbol from field of a JSON object payload, it
+// returns an empty string when there is none.
+func assetOf(value []byte, field string) string {
+	if field == "" {
+		return ""
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(value, &payload); err != nil {
+		return ""
+	}
+
+	asset, _ := payload[field].(string)
+	return asset
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/health"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/metrics"
+)
+
+// StartLeaderElection campaigns for LEADER_KEY when LEADER_ELECTION is set,
+// so that only one replica records and produces the upstream WebSocket
+// data. The others keep their connection open as hot standbys and only
+// deliver it to their own subscribers.
+func (s *streamService) StartLeaderElection(ctx context.Context) error {
+	if !s.config.LeaderElection {
+		return nil
+	}
+
+	id := s.config.LeaderID
+	if id == "" {
+		id, _ = os.Hostname()
+	}
+
+	leader := s.redis.NewLeaderElection(s.config.LeaderKey, id, time.Duration(s.config.LeaderTTL)*time.Millisecond)
+
+	if err := leader.RegisterHealthCheck(s.health, health.OptionsFromConfig(s.config.HealthCheck("leader"))); err != nil {
+		return err
+	}
+
+	s.metrics.Gauge("leader", "1 while this replica holds the leader lease.", func() []metrics.Sample {
+		value := 0.0
+		if leader.IsLeader() {
+			value = 1
+		}
+		return []metrics.Sample{{Labels: map[string]string{"key": s.config.LeaderKey}, Value: value}}
+	})
+
+	s.leader = leader
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		leader.Run(ctx)
+	}()
+
+	return nil
+}
+
+// isLeader is always true when leader election is disabled.
+func (s *streamService) isLeader() bool {
+	return s.leader == nil || s.leader.IsLeader()
+}
+
+func (s *streamService) leaderToken() string {
+	if s.leader == nil {
+		return ""
+	}
+	return strconv.FormatInt(s.leader.Token(), 10)
+}
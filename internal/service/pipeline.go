@@ -0,0 +1,280 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/health"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/metrics"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/transport"
+)
+
+// StartConsumer subscribes to KafkaConsumeTopics and runs the consumer loop
+// until the service is shut down. The group consumer only caches messages,
+// see StartKafkaFanOut for the fan-out.
+func (s *streamService) StartConsumer(ctx context.Context) error {
+	consumer, err := s.kafka.NewKafkaConsumer(ctx, s.config.KafkaConsumeTopics)
+	if err != nil {
+		return err
+	}
+
+	consumer.SetRetryPolicy(s.config.KafkaHandlerRetries, time.Duration(s.config.KafkaRetryBackoff)*time.Millisecond)
+	if s.config.KafkaDLQEnabled {
+		consumer.SetDeadLetter(s.producer)
+	}
+
+	// Between two beats the consumer may wait out every retry backoff and
+	// the rewind delay.
+	backoff := time.Duration(s.config.KafkaRetryBackoff) * time.Millisecond
+	consumer.SetHeartbeat(s.registerLoop("kafka-consumer", backoff<<uint(s.config.KafkaHandlerRetries)+s.kafka.Reconnect.InitialInterval))
+
+	consumer.SetLagMonitor(time.Duration(s.config.KafkaLagInterval)*time.Millisecond, s.config.KafkaMaxLag)
+
+	// Lag only gates readiness when a threshold is configured.
+	opts := health.OptionsFromConfig(s.config.HealthCheck("kafka-lag"))
+	opts.Criticality = health.Optional
+	if s.config.KafkaMaxLag > 0 {
+		opts.Criticality = health.Required
+	}
+
+	if err := consumer.RegisterHealthCheck(s.health, opts); err != nil {
+		return err
+	}
+
+	s.metrics.Gauge("kafka_consumer_lag", "Messages between the committed offset and the high watermark per partition.", func() []metrics.Sample {
+		lags := consumer.Lag()
+		samples := make([]metrics.Sample, 0, len(lags))
+		for _, l := range lags {
+			samples = append(samples, metrics.Sample{
+				Labels: map[string]string{"topic": l.Topic, "partition": strconv.Itoa(int(l.Partition))},
+				Value:  float64(l.Lag),
+			})
+		}
+		return samples
+	})
+
+	for _, topic := range s.config.KafkaConsumeTopics {
+		consumer.Handle(topic, transport.MessageHandlerFunc(s.cacheMessage))
+	}
+
+	s.consumer = consumer
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := consumer.Run(ctx); err != nil {
+			log.Printf("Kafka consumer exited: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// StartKafkaFanOut delivers KafkaConsumeTopics to the local subscribers. It
+// reads every partition, since with several replicas the group consumer
+// only sees some of them on each pod, and it runs apart from the cache so
+// that a Redis outage never holds up or retries live data.
+func (s *streamService) StartKafkaFanOut(ctx context.Context) error {
+	broadcast, err := s.kafka.NewKafkaBroadcast(ctx, s.config.KafkaConsumeTopics)
+	if err != nil {
+		return err
+	}
+
+	broadcast.SetHeartbeat(s.registerLoop("kafka-fanout", s.kafka.Reconnect.InitialInterval))
+
+	for _, topic := range s.config.KafkaConsumeTopics {
+		broadcast.Handle(topic, transport.MessageHandlerFunc(s.fanOut))
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := broadcast.Run(ctx); err != nil {
+			log.Printf("Kafka broadcast consumer exited: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// ReplayDeadLetters republishes up to limit dead-lettered messages of a
+// consumed topic back to that topic.
+func (s *streamService) ReplayDeadLetters(ctx context.Context, topic string, limit int) (int, error) {
+	if !contains(s.config.KafkaConsumeTopics, topic) {
+		return 0, ErrUnknownTopic
+	}
+
+	if s.producer == nil {
+		return 0, fmt.Errorf("kafka producer is not ready")
+	}
+
+	return s.kafka.ReplayDeadLetters(ctx, s.producer, topic, limit)
+}
+
+// StartNats routes every subject in NATS_SUBJECTS into the fan-out.
+func (s *streamService) StartNats(ctx context.Context) error {
+	for _, subject := range s.config.NatsSubjects {
+		if err := s.nats.Handle(subject, transport.MessageHandlerFunc(s.fanOut)); err != nil {
+			return fmt.Errorf("failed to subscribe to nats subject %s: %v", subject, err)
+		}
+	}
+	return nil
+}
+
+// StartRedisStreams consumes REDIS_STREAMS as a member of
+// REDIS_STREAM_GROUP to cache the entries, and tails them on every replica
+// for the fan-out, like the Kafka topics.
+func (s *streamService) StartRedisStreams(ctx context.Context) error {
+	if len(s.config.RedisStreams) == 0 {
+		return nil
+	}
+
+	name := s.config.RedisStreamConsumer
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+
+	consumer, err := s.redis.NewStreamConsumer(ctx, s.config.RedisStreamGroup, name, s.config.RedisStreams)
+	if err != nil {
+		return err
+	}
+
+	consumer.SetBlock(time.Duration(s.config.RedisStreamBlock) * time.Millisecond)
+	consumer.SetClaimIdle(time.Duration(s.config.RedisStreamClaimIdle) * time.Millisecond)
+	consumer.SetMaxDeliveries(s.config.RedisStreamMaxDelivery)
+
+	if err := consumer.RegisterHealthCheck(s.health, health.OptionsFromConfig(s.config.HealthCheck("redis-streams"))); err != nil {
+		return err
+	}
+
+	tail := s.redis.NewStreamTail(s.config.RedisStreams)
+	tail.SetBlock(time.Duration(s.config.RedisStreamBlock) * time.Millisecond)
+
+	for _, stream := range s.config.RedisStreams {
+		consumer.Handle(stream, transport.MessageHandlerFunc(s.cacheMessage))
+		tail.Handle(stream, transport.MessageHandlerFunc(s.fanOut))
+	}
+
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		consumer.Run(ctx)
+	}()
+	go func() {
+		defer s.wg.Done()
+		tail.Run(ctx)
+	}()
+
+	return nil
+}
+
+// StartRedisPubSub routes every channel matching REDIS_PUBSUB_PATTERNS into
+// the fan-out, like the NATS subjects.
+func (s *streamService) StartRedisPubSub(ctx context.Context) error {
+	if len(s.config.RedisPubSubPatterns) == 0 {
+		return nil
+	}
+
+	sub, err := s.redis.Subscribe(ctx, s.config.RedisPubSubPatterns...)
+	if err != nil {
+		return err
+	}
+
+	if err := sub.RegisterHealthCheck(s.health, health.OptionsFromConfig(s.config.HealthCheck("redis-pubsub"))); err != nil {
+		return err
+	}
+
+	sub.Handle(transport.MessageHandlerFunc(s.fanOut))
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := sub.Run(ctx); err != nil {
+			log.Printf("Redis subscription exited: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// ingestMessage forwards upstream WebSocket data to KafkaProduceTopic, to
+// REDIS_STREAM_PRODUCE when set, and to the subscribers of its asset,
+// without blocking the read loop. With leader election enabled every
+// replica still serves its own subscribers, but only the leader records the
+// event and produces it, stamping its fencing token on the message.
+func (s *streamService) ingestMessage(ctx context.Context, msg *transport.Message) error {
+	if asset := assetOf(msg.Value, s.config.WsAssetField); asset != "" {
+		msg.Topic = asset
+	}
+
+	leader := s.isLeader()
+	if token := s.leaderToken(); leader && token != "" {
+		msg.Headers[transport.HeaderLeaderToken] = token
+	}
+
+	s.publishEvent(msg, leader)
+
+	if !leader {
+		return nil
+	}
+
+	if s.streamOut != nil {
+		s.streamOut.Enqueue(func(ctx context.Context) error {
+			_, err := s.redis.StreamPublish(ctx, s.config.RedisStreamProduce, msg.Key, msg.Value, msg.Headers, s.config.RedisStreamMaxLen)
+			return err
+		})
+	}
+
+	return s.producer.PublishAsync("", msg.Key, msg.Value, msg.Headers, func(report transport.DeliveryReport) {
+		if report.Err != nil {
+			log.Printf("Failed to forward websocket message to %s: %v", report.Topic, report.Err)
+		}
+	})
+}
+
+// cacheMessage keeps the latest value per key and a bounded history list in
+// Redis so that /read can serve them. Every failure is a Redis one, so the
+// message is redelivered rather than dead-lettered.
+func (s *streamService) cacheMessage(ctx context.Context, msg *transport.Message) error {
+	key := streamKey(msg.Topic, string(msg.Key))
+	value := jsonValue(msg.Value)
+
+	if err := s.redis.SetKeyValue(ctx, key, value); err != nil {
+		return transport.Transient(err)
+	}
+
+	if err := s.redis.PushList(ctx, historyKey(key), value); err != nil {
+		return transport.Transient(err)
+	}
+
+	return transport.Transient(s.redis.TrimList(ctx, historyKey(key), 0, int64(s.config.StreamHistorySize-1)))
+}
+
+const streamPrefix = "stream:"
+
+func streamKey(topic, key string) string {
+	if key == "" {
+		return streamPrefix + topic
+	}
+	return streamPrefix + topic + ":" + key
+}
+
+// historyKey lives outside the stream: namespace so that prefix reads only
+// ever match latest values.
+func historyKey(key string) string {
+	return "history:" + strings.TrimPrefix(key, streamPrefix)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/transport"
+)
+
+const (
+	DefaultReadLimit = 100
+	MaxReadLimit     = 1000
+)
+
+// ReadQuery selects cached stream data. Key and Prefix are relative to the
+// stream namespace, e.g. "topic1:BTCUSDT" or "topic1".
+type ReadQuery struct {
+	Key     string
+	Prefix  string
+	History bool
+	Start   int64
+	Limit   int64
+}
+
+type ReadResult struct {
+	Key    string      `json:"key,omitempty"`
+	Prefix string      `json:"prefix,omitempty"`
+	Value  interface{} `json:"value,omitempty"`
+	Values interface{} `json:"values,omitempty"`
+}
+
+var (
+	ErrInvalidQuery = errors.New("either key or prefix is required")
+	ErrNotFound     = errors.New("no data cached for key")
+
+	// errPageFull stops a prefix scan once the page is complete.
+	errPageFull = errors.New("page is full")
+)
+
+// Read serves the latest value of a key, its newest-first history, or the
+// latest values of every key under a prefix.
+func (s *streamService) Read(ctx context.Context, q ReadQuery) (int, *ReadResult, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultReadLimit
+	}
+	if q.Limit > MaxReadLimit {
+		q.Limit = MaxReadLimit
+	}
+	if q.Start < 0 {
+		q.Start = 0
+	}
+
+	switch {
+	case q.Key != "" && q.History:
+		return s.readHistory(ctx, q)
+	case q.Key != "":
+		return s.readLatest(ctx, q)
+	case q.Prefix != "":
+		return s.readPrefix(ctx, q)
+	}
+
+	return http.StatusBadRequest, nil, ErrInvalidQuery
+}
+
+func (s *streamService) readLatest(ctx context.Context, q ReadQuery) (int, *ReadResult, error) {
+	value, err := s.redis.GetKeyValue(ctx, streamPrefix+q.Key)
+	if errors.Is(err, transport.ErrKeyNotFound) {
+		return http.StatusNotFound, nil, ErrNotFound
+	}
+	if err != nil {
+		return http.StatusServiceUnavailable, nil, err
+	}
+
+	return http.StatusOK, &ReadResult{Key: q.Key, Value: cachedValue(value)}, nil
+}
+
+func (s *streamService) readHistory(ctx context.Context, q ReadQuery) (int, *ReadResult, error) {
+	values, err := s.redis.GetList(ctx, historyKey(streamPrefix+q.Key), q.Start, q.Start+q.Limit-1)
+	if err != nil {
+		return http.StatusServiceUnavailable, nil, err
+	}
+
+	if len(values) == 0 {
+		return http.StatusNotFound, nil, ErrNotFound
+	}
+	for i, value := range values {
+		values[i] = cachedValue(value)
+	}
+
+	return http.StatusOK, &ReadResult{Key: q.Key, Values: values}, nil
+}
+
+// readPrefix scans the keys under the prefix and stops once Start+Limit of
+// them have been read, so a large namespace is never loaded at once. Pages
+// follow the SCAN order, which only stays stable while no keys are added or
+// removed.
+func (s *streamService) readPrefix(ctx context.Context, q ReadQuery) (int, *ReadResult, error) {
+	seen := make(map[string]struct{})
+	values := make(map[string]interface{})
+
+	err := s.redis.ScanValues(ctx, streamPrefix+q.Prefix, transport.DefaultScanBatch, func(key string, value interface{}) error {
+		if _, ok := seen[key]; ok {
+			return nil
+		}
+		seen[key] = struct{}{}
+
+		if int64(len(seen)) > q.Start {
+			values[strings.TrimPrefix(key, streamPrefix)] = cachedValue(value)
+		}
+		if int64(len(values)) >= q.Limit {
+			return errPageFull
+		}
+		return nil
+	})
+	if err != nil && err != errPageFull {
+		return http.StatusServiceUnavailable, nil, err
+	}
+
+	return http.StatusOK, &ReadResult{Prefix: q.Prefix, Values: values}, nil
+}
+
+// cachedValue restores a payload cached by cacheMessage. JSON keeps it as an
+// object, but msgpack stores the raw JSON as bytes, which would otherwise be
+// served base64 encoded.
+func cachedValue(value interface{}) interface{} {
+	if raw, ok := value.([]byte); ok {
+		return jsonValue(raw)
+	}
+	return value
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/config"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/transport"
+)
+
+// newTestService returns a service whose Redis is in memory, without any of
+// the other dependencies.
+func newTestService(t *testing.T, codec string) *streamService {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	cnf := &config.Config{RedisCodec: codec, RedisOpTimeout: 1000, StreamHistorySize: 10}
+
+	rd, err := transport.NewRedisClient("redis://"+mr.Addr(), cnf)
+	if err != nil {
+		t.Fatalf("NewRedisClient failed: %v", err)
+	}
+
+	return &streamService{config: cnf, redis: rd}
+}
+
+func cache(t *testing.T, s *streamService, topic, key, value string) {
+	t.Helper()
+
+	msg := &transport.Message{Topic: topic, Key: []byte(key), Value: []byte(value), Timestamp: time.Now()}
+	if err := s.cacheMessage(context.Background(), msg); err != nil {
+		t.Fatalf("cacheMessage failed: %v", err)
+	}
+}
+
+func TestReadServesJSONWithEveryCodec(t *testing.T) {
+	for _, codec := range []string{"json", "msgpack"} {
+		t.Run(codec, func(t *testing.T) {
+			s := newTestService(t, codec)
+			cache(t, s, "topic1", "BTCUSDT", `{"p":"30000"}`)
+			cache(t, s, "topic1", "BTCUSDT", `{"p":"30001"}`)
+
+			queries := []ReadQuery{
+				{Key: "topic1:BTCUSDT"},
+				{Key: "topic1:BTCUSDT", History: true},
+				{Prefix: "topic1"},
+			}
+			want := []string{
+				`{"key":"topic1:BTCUSDT","value":{"p":"30001"}}`,
+				`{"key":"topic1:BTCUSDT","values":[{"p":"30001"},{"p":"30000"}]}`,
+				`{"prefix":"topic1","values":{"topic1:BTCUSDT":{"p":"30001"}}}`,
+			}
+
+			for i, q := range queries {
+				status, result, err := s.Read(context.Background(), q)
+				if err != nil || status != http.StatusOK {
+					t.Fatalf("Read(%+v) = %d, %v", q, status, err)
+				}
+				got, _ := json.Marshal(result)
+				if string(got) != want[i] {
+					t.Errorf("Read(%+v) = %s, want %s", q, got, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadPrefixPages(t *testing.T) {
+	s := newTestService(t, "json")
+	symbols := []string{"ADAUSDT", "BNBUSDT", "BTCUSDT", "ETHUSDT", "XRPUSDT"}
+	for _, symbol := range symbols {
+		cache(t, s, "topic1", symbol, `{"s":"`+symbol+`"}`)
+	}
+	cache(t, s, "topic2", "BTCUSDT", `{}`)
+
+	seen := make(map[string]bool)
+	for start := int64(0); start < int64(len(symbols)); start += 2 {
+		_, result, err := s.Read(context.Background(), ReadQuery{Prefix: "topic1", Start: start, Limit: 2})
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+
+		values := result.Values.(map[string]interface{})
+		if want := min64(2, int64(len(symbols))-start); int64(len(values)) != want {
+			t.Errorf("page at %d has %d keys, want %d", start, len(values), want)
+		}
+		for key := range values {
+			if seen[key] {
+				t.Errorf("key %s served on more than one page", key)
+			}
+			seen[key] = true
+		}
+	}
+
+	if len(seen) != len(symbols) {
+		t.Errorf("pages served %d keys, want %d", len(seen), len(symbols))
+	}
+
+	_, result, err := s.Read(context.Background(), ReadQuery{Prefix: "topic1", Start: 10})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if values := result.Values.(map[string]interface{}); len(values) != 0 {
+		t.Errorf("page past the end has %d keys, want none", len(values))
+	}
+}
+
+func TestReadHistoryPages(t *testing.T) {
+	s := newTestService(t, "json")
+	for _, price := range []string{"1", "2", "3"} {
+		cache(t, s, "topic1", "BTCUSDT", `{"p":"`+price+`"}`)
+	}
+
+	_, result, err := s.Read(context.Background(), ReadQuery{Key: "topic1:BTCUSDT", History: true, Start: 1, Limit: 1})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	got, _ := json.Marshal(result.Values)
+	if string(got) != `[{"p":"2"}]` {
+		t.Errorf("history page = %s, want [{\"p\":\"2\"}]", got)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	s := newTestService(t, "json")
+
+	tests := []struct {
+		name   string
+		query  ReadQuery
+		status int
+		err    error
+	}{
+		{"no key or prefix", ReadQuery{}, http.StatusBadRequest, ErrInvalidQuery},
+		{"unknown key", ReadQuery{Key: "topic1:NOPE"}, http.StatusNotFound, ErrNotFound},
+		{"empty history", ReadQuery{Key: "topic1:NOPE", History: true}, http.StatusNotFound, ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, err := s.Read(context.Background(), tt.query)
+			if status != tt.status || err != tt.err {
+				t.Errorf("Read(%+v) = %d, %v, want %d, %v", tt.query, status, err, tt.status, tt.err)
+			}
+		})
+	}
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -2,93 +2,308 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/config"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/health"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/hub"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/metrics"
 	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/transport"
 )
 
-type ServiceStatuses struct {
-	redisConnected     bool
-	websocketConnected bool
-}
+var (
+	ErrUnknownTopic = errors.New("topic is not consumed by this service")
+	ErrUnknownAsset = errors.New("asset is not subscribed")
+	ErrInvalidAsset = errors.New("asset must not be empty")
+)
 
 type StreamService interface {
 	Live(ctx context.Context) (int, bool, error)
-	Read(ctx context.Context) (int, bool, error)
+	Ready(ctx context.Context) (int, bool, error)
+	Startup(ctx context.Context) (int, bool, error)
+	Health(ctx context.Context) (int, health.Report)
+	ReplayDeadLetters(ctx context.Context, topic string, limit int) (int, error)
+	Subscribe(channels ...string) *hub.Subscriber
+	Unsubscribe(sub *hub.Subscriber)
+	CloseSubscribers()
+	RecentEvents(ctx context.Context, channels []string, afterID int64) ([]*hub.Message, error)
+	Assets(ctx context.Context) []string
+	AddAsset(ctx context.Context, asset string) error
+	RemoveAsset(ctx context.Context, asset string) error
+	WriteMetrics(w io.Writer) error
+	Shutdown(ctx context.Context) error
+	Read(ctx context.Context, q ReadQuery) (int, *ReadResult, error)
 }
 
 type streamService struct {
 	config    *config.Config
-	assets    []string
 	kafka     *transport.KafkaClient
 	redis     *transport.RedisClient
 	webSocket *transport.WSClient
 	nats      *transport.NatsClient
-	status    *ServiceStatuses
+	health    *health.Registry
+	metrics   *metrics.Registry
+	hub       *hub.Hub
+	consumer  *transport.KafkaConsumer
+	producer  *transport.KafkaProducer
+	leader    *transport.LeaderElection
+	events    *backgroundWriter
+	streamOut *backgroundWriter
+	writers   []*backgroundWriter
+	liveness  *health.Liveness
+	lastEvent int64
+	started   int32
+	startErr  error
+	mu        sync.RWMutex
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
 }
 
-func NewStreamService(kf *transport.KafkaClient, rd *transport.RedisClient, ws *transport.WSClient, nc *transport.NatsClient, cnf *config.Config) StreamService {
+func NewStreamService(kf *transport.KafkaClient, rd *transport.RedisClient, ws *transport.WSClient, nc *transport.NatsClient, registry *health.Registry, cnf *config.Config) StreamService {
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
 
-	service := &streamService{kafka: kf, redis: rd, webSocket: ws, nats: nc, config: cnf, status: &ServiceStatuses{}}
+	service := &streamService{kafka: kf, redis: rd, webSocket: ws, nats: nc, health: registry, metrics: metrics.NewRegistry(), hub: hub.NewHub(cnf.WsClientBuffer), liveness: health.NewLiveness(), config: cnf, cancel: cancel}
 
-	service.ConnectToWebSocket(ctx)
-	go service.webSocket.MonitorConnection()
+	service.events = service.startWriter(ctx, "events")
+	if cnf.RedisStreamProduce != "" {
+		service.streamOut = service.startWriter(ctx, "redis-stream")
+	}
+	service.metrics.Counter("background_writes_dropped_total", "Redis writes dropped because their queue was full.", func() []metrics.Sample {
+		samples := make([]metrics.Sample, 0, len(service.writers))
+		for _, w := range service.writers {
+			samples = append(samples, metrics.Sample{Labels: map[string]string{"queue": w.name}, Value: float64(w.Dropped())})
+		}
+		return samples
+	})
 
 	service.MonitorServices(ctx)
 
+	go service.start(ctx)
+
 	return service
 }
 
+// start performs the initial connections to every dependency. The startup
+// probe keeps failing until all of them have completed. A failed connection
+// is reported by the probe rather than exiting, and cancelling ctx on
+// shutdown just stops it.
+func (s *streamService) start(ctx context.Context) {
+	err := s.connect(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Startup failed: %v", err)
+
+		s.mu.Lock()
+		s.startErr = err
+		s.mu.Unlock()
+		return
+	}
+
+	atomic.StoreInt32(&s.started, 1)
+}
+
+func (s *streamService) connect(ctx context.Context) error {
+	err := s.kafka.Connect(ctx)
+	if err != nil {
+		return fmt.Errorf("error connecting to kafka brokers: %v", err)
+	}
+	fmt.Println("Connected to the Kafka brokers")
+
+	s.producer, err = s.kafka.NewKafkaProducer(ctx, s.config.KafkaProduceTopic)
+	if err != nil {
+		return fmt.Errorf("error creating kafka producer: %v", err)
+	}
+
+	err = s.StartConsumer(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting kafka consumer: %v", err)
+	}
+
+	err = s.StartKafkaFanOut(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting kafka fan-out: %v", err)
+	}
+
+	err = s.StartNats(ctx)
+	if err != nil {
+		return fmt.Errorf("error subscribing to nats: %v", err)
+	}
+
+	err = s.StartRedisStreams(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting redis stream consumer: %v", err)
+	}
+
+	err = s.StartRedisPubSub(ctx)
+	if err != nil {
+		return fmt.Errorf("error subscribing to redis channels: %v", err)
+	}
+
+	err = s.StartLeaderElection(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting leader election: %v", err)
+	}
+
+	// A read loop hears at least a pong every PingPeriod, the ping loop ticks
+	// every PingPeriod.
+	s.webSocket.SetHeartbeats(
+		s.registerLoop("websocket-read", s.webSocket.PongWait+s.webSocket.PingPeriod),
+		s.registerLoop("websocket-ping", 2*s.webSocket.PingPeriod),
+	)
+	s.webSocket.Handle(transport.MessageHandlerFunc(s.ingestMessage))
+	s.webSocket.SetSubscriptionFrames(s.config.WsSubscribeFrame, s.config.WsUnsubscribeFrame)
+	for _, asset := range s.config.WsAssets {
+		s.webSocket.Subscribe(asset)
+	}
+
+	err = s.ConnectToWebSocket(ctx)
+	if err != nil {
+		return err
+	}
+	go s.webSocket.MonitorConnection(ctx)
+
+	return nil
+}
+
 func (s *streamService) ConnectToWebSocket(ctx context.Context) error {
-	err := s.webSocket.Connect()
+	err := s.webSocket.Connect(ctx)
 	if err != nil {
-		log.Fatalf("Fatal error connecting to websocket server: %v", err)
+		return fmt.Errorf("error connecting to websocket server: %v", err)
 	}
 	fmt.Println("Connected to the WebSocket server")
 
 	return nil
 }
 
-func (s *streamService) MonitorServices(ctx context.Context) {
-	log.Println("## Service monitoring started")
+// startWriter starts a background writer that lives as long as the service.
+func (s *streamService) startWriter(ctx context.Context, name string) *backgroundWriter {
+	w := newBackgroundWriter(name, s.config.RedisWriteQueue)
+	s.writers = append(s.writers, w)
 
+	s.wg.Add(1)
 	go func() {
-		for {
-			connected := s.webSocket.IsConnected()
-			if s.status.websocketConnected != connected {
-				s.status.websocketConnected = connected
-				fmt.Println("WebSocket connection status:", connected)
-			}
-			time.Sleep(time.Second)
-		}
+		defer s.wg.Done()
+		w.Run(ctx)
 	}()
 
-	go func() {
-		for {
-			connected := s.redis.IsConnected()
-			if s.status.redisConnected != connected {
-				s.status.redisConnected = connected
-				fmt.Println("Redis connection status:", connected)
-			}
-			time.Sleep(time.Second)
-		}
-	}()
+	return w
 }
 
+// MonitorServices starts the dependency checks.
+func (s *streamService) MonitorServices(ctx context.Context) {
+	log.Println("## Service monitoring started")
+
+	s.health.Start(ctx)
+}
+
+// registerLoop adds a loop to the liveness probe. It may stay silent for
+// LIVENESS_TIMEOUT, or for min when the loop legitimately idles longer.
+func (s *streamService) registerLoop(name string, min time.Duration) *health.Beat {
+	timeout := time.Duration(s.config.LivenessTimeout) * time.Millisecond
+	if timeout < min {
+		timeout = min
+	}
+	return s.liveness.Register(name, timeout)
+}
+
+// Live only reflects the health of the process itself, so that Kubernetes
+// does not restart the pod while a dependency is down: it fails when one of
+// the processing loops stopped making progress while it should be working.
 func (s *streamService) Live(ctx context.Context) (int, bool, error) {
-	fmt.Println("Redis:", s.status.redisConnected, "WebSocket:", s.status.websocketConnected)
-	if s.status.redisConnected && s.status.websocketConnected {
-		return http.StatusOK, true, nil
+	if stalled := s.liveness.Stalled(); len(stalled) > 0 {
+		return http.StatusServiceUnavailable, false, fmt.Errorf("loops stalled: %s", strings.Join(stalled, ", "))
+	}
+	return http.StatusOK, true, nil
+}
+
+func (s *streamService) Ready(ctx context.Context) (int, bool, error) {
+	ok, failing := s.health.Ready()
+	if !ok {
+		return http.StatusServiceUnavailable, false, fmt.Errorf("services are not fully operational: %s", strings.Join(failing, ", "))
+	}
+	return http.StatusOK, true, nil
+}
+
+func (s *streamService) Startup(ctx context.Context) (int, bool, error) {
+	if atomic.LoadInt32(&s.started) == 0 {
+		s.mu.RLock()
+		err := s.startErr
+		s.mu.RUnlock()
+
+		if err != nil {
+			return http.StatusServiceUnavailable, false, fmt.Errorf("initial connections failed: %v", err)
+		}
+		return http.StatusServiceUnavailable, false, fmt.Errorf("initial connections have not completed")
+	}
+
+	ok, pending := s.health.Started()
+	if !ok {
+		return http.StatusServiceUnavailable, false, fmt.Errorf("initial connections have not completed: %s", strings.Join(pending, ", "))
 	}
-	return http.StatusServiceUnavailable, false, fmt.Errorf("services are not fully operational")
+	return http.StatusOK, true, nil
 }
 
-func (s *streamService) Read(ctx context.Context) (int, bool, error) {
-	return s.Live(ctx)
+func (s *streamService) Health(ctx context.Context) (int, health.Report) {
+	report := s.health.Report()
+	if report.Status != health.StatusUp {
+		return http.StatusServiceUnavailable, report
+	}
+	return http.StatusOK, report
+}
+
+func (s *streamService) WriteMetrics(w io.Writer) error {
+	return s.metrics.Write(w)
+}
+
+// producerFlushTimeout bounds the final flush of the Kafka producer. It is
+// not taken from the shutdown deadline, which waiting for the pipelines may
+// already have used up.
+const producerFlushTimeout = 5 * time.Second
+
+// Shutdown stops the pipelines and waits for in-flight messages to be
+// handled, or for ctx to expire. The connections are closed either way.
+func (s *streamService) Shutdown(ctx context.Context) error {
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Pipelines did not stop in time, closing anyway: %v", ctx.Err())
+	}
+
+	// The WebSocket read loop publishes to the producer, it has to be gone
+	// before the producer is closed.
+	s.webSocket.Close()
+
+	var err error
+	if s.producer != nil {
+		flushCtx, cancel := context.WithTimeout(context.Background(), producerFlushTimeout)
+		err = s.producer.Close(flushCtx)
+		cancel()
+	}
+
+	s.kafka.Close()
+	if natsErr := s.nats.Close(ctx); err == nil {
+		err = natsErr
+	}
+	s.redis.Close()
+
+	return err
 }
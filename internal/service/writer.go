@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+)
+
+// DefaultWriteQueue is used when REDIS_WRITE_QUEUE is not positive.
+const DefaultWriteQueue = 1024
+
+type write func(ctx context.Context) error
+
+// backgroundWriter runs Redis writes off the pipeline loops, which must
+// never wait on Redis. The queue is bounded: when it is full the write is
+// dropped and counted rather than blocking the caller.
+type backgroundWriter struct {
+	name    string
+	queue   chan write
+	dropped int64
+}
+
+func newBackgroundWriter(name string, size int) *backgroundWriter {
+	if size <= 0 {
+		size = DefaultWriteQueue
+	}
+	return &backgroundWriter{name: name, queue: make(chan write, size)}
+}
+
+// Enqueue schedules w and reports whether it was accepted.
+func (b *backgroundWriter) Enqueue(w write) bool {
+	select {
+	case b.queue <- w:
+		return true
+	default:
+		atomic.AddInt64(&b.dropped, 1)
+		return false
+	}
+}
+
+func (b *backgroundWriter) Dropped() int64 {
+	return atomic.LoadInt64(&b.dropped)
+}
+
+// Run performs the queued writes until ctx is cancelled, whatever is still
+// queued then is discarded.
+func (b *backgroundWriter) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			if n := len(b.queue); n > 0 {
+				log.Printf("Discarding %d pending %s writes", n, b.name)
+			}
+			return
+		case w := <-b.queue:
+			if err := w(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Background %s write failed: %v", b.name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	type ticker struct {
+		Symbol string  `json:"s" msgpack:"s"`
+		Price  float64 `json:"p" msgpack:"p"`
+	}
+
+	for _, name := range []string{"json", "msgpack"} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := CodecByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			in := ticker{Symbol: "BTCUSDT", Price: 30000.5}
+			data, err := codec.Marshal(in)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+
+			var out ticker
+			if err := codec.Unmarshal(data, &out); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if !reflect.DeepEqual(out, in) {
+				t.Errorf("round trip = %+v, want %+v", out, in)
+			}
+		})
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	codec := ProtobufCodec{}
+
+	data, err := codec.Marshal(wrapperspb.String("BTCUSDT"))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	// GetAs[*wrapperspb.StringValue] unmarshals into a pointer to a nil
+	// message pointer.
+	var out *wrapperspb.StringValue
+	if err := codec.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !proto.Equal(out, wrapperspb.String("BTCUSDT")) {
+		t.Errorf("round trip = %v, want BTCUSDT", out)
+	}
+
+	if _, err := codec.Marshal(map[string]interface{}{"s": "BTCUSDT"}); err == nil {
+		t.Error("Marshal of a non-message succeeded, want an error")
+	}
+
+	var untyped interface{}
+	if err := codec.Unmarshal(data, &untyped); err == nil {
+		t.Error("Unmarshal into interface{} succeeded, want an error")
+	}
+}
+
+func TestCodecByName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"", "json", false},
+		{"json", "json", false},
+		{"msgpack", "msgpack", false},
+		{"protobuf", "protobuf", false},
+		{"xml", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec, err := CodecByName(tt.name)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("CodecByName(%q) succeeded, want an error", tt.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CodecByName(%q) failed: %v", tt.name, err)
+			}
+			if codec.Name() != tt.want {
+				t.Errorf("CodecByName(%q) = %s, want %s", tt.name, codec.Name(), tt.want)
+			}
+		})
+	}
+}
@@ -1,89 +1,204 @@
 package transport
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/health"
 )
 
 type KafkaClient struct {
 	Brokers       []string
 	ConsumerGroup string
-	MaxRetry      int
-	RetryWait     time.Duration
+	Reconnect     ReconnectPolicy
+	Topics        []string
+	admin         *kafka.AdminClient
 }
 
-func NewKafkaClient(brokers []string, consumerGroup string, maxRetry int, retryWait time.Duration) (*KafkaClient, error) {
+func NewKafkaClient(brokers []string, consumerGroup string, topics []string, reconnect ReconnectPolicy) (*KafkaClient, error) {
 	return &KafkaClient{
 		Brokers:       brokers,
 		ConsumerGroup: consumerGroup,
-		MaxRetry:      maxRetry,
-		RetryWait:     retryWait,
+		Topics:        topics,
+		Reconnect:     reconnect,
 	}, nil
 }
 
-func (k *KafkaClient) NewConsumer(topics []string, autoCommit bool) (*kafka.Consumer, error) {
-	var consumer *kafka.Consumer
-	var err error
-
-	for i := 0; i < k.MaxRetry; i++ {
-		consumer, err = kafka.NewConsumer(&kafka.ConfigMap{
-			"bootstrap.servers":  k.Brokers,
-			"group.id":           k.ConsumerGroup,
-			"auto.offset.reset":  "earliest",
-			"enable.auto.commit": autoCommit,
-		})
-
-		if err != nil {
-			fmt.Printf("Failed to create consumer, retry %d/%d\n", i+1, k.MaxRetry)
-			time.Sleep(k.RetryWait)
-		} else {
-			break
-		}
+// Connect creates the admin client and waits until the brokers answer, for
+// as long as the reconnect policy and ctx allow.
+func (k *KafkaClient) Connect(ctx context.Context) error {
+	admin, err := kafka.NewAdminClient(&kafka.ConfigMap{
+		"bootstrap.servers": strings.Join(k.Brokers, ","),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := k.awaitBrokers(ctx, "Connecting to kafka brokers", admin); err != nil {
+		admin.Close()
+		return err
+	}
+
+	k.admin = admin
+	return nil
+}
+
+type metadataClient interface {
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+}
+
+// awaitBrokers retries a metadata request until the brokers answer. Only the
+// connection is retried: constructing a client fails on invalid settings,
+// which no amount of retrying fixes.
+func (k *KafkaClient) awaitBrokers(ctx context.Context, name string, client metadataClient) error {
+	return k.Reconnect.Retry(ctx, name, func() error {
+		_, err := client.GetMetadata(nil, false, timeoutMs(ctx, 5000))
+		return err
+	})
+}
+
+func (k *KafkaClient) IsConnected() bool {
+	if k.admin == nil {
+		return false
 	}
 
+	_, err := k.admin.GetMetadata(nil, false, 1000)
+	return err == nil
+}
+
+// Check queries the cluster metadata and verifies that every topic the
+// service consumes from or produces to exists and has a leader for each of
+// its partitions.
+func (k *KafkaClient) Check(ctx context.Context) error {
+	if k.admin == nil {
+		return fmt.Errorf("kafka admin client is not connected")
+	}
+
+	// Fetch all topics rather than the configured ones, requesting a single
+	// topic may auto-create it on the broker.
+	metadata, err := k.admin.GetMetadata(nil, true, timeoutMs(ctx, 1000))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create consumer after %d retries: %v", k.MaxRetry, err)
+		return fmt.Errorf("failed to fetch kafka metadata: %v", err)
+	}
+
+	if len(metadata.Brokers) == 0 {
+		return fmt.Errorf("kafka metadata returned no brokers")
 	}
 
-	for i := 0; i < k.MaxRetry; i++ {
-		err = consumer.SubscribeTopics(topics, nil)
-		if err != nil {
-			fmt.Printf("Failed to subscribe to topics, retry %d/%d\n", i+1, k.MaxRetry)
-			time.Sleep(k.RetryWait)
-		} else {
-			break
+	var problems []string
+	for _, topic := range k.Topics {
+		if err := checkTopic(metadata, topic); err != nil {
+			problems = append(problems, err.Error())
 		}
 	}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to subscribe to topics after %d retries: %v", k.MaxRetry, err)
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
 	}
 
-	fmt.Println("Consumer created and subscribed to topics:", topics)
-	return consumer, nil
+	return nil
 }
 
-func (k *KafkaClient) NewProducer() (*kafka.Producer, error) {
-	var producer *kafka.Producer
-	var err error
+func checkTopic(metadata *kafka.Metadata, topic string) error {
+	tm, ok := metadata.Topics[topic]
+	if !ok {
+		return fmt.Errorf("topic %s does not exist", topic)
+	}
+
+	if tm.Error.Code() != kafka.ErrNoError {
+		return fmt.Errorf("topic %s: %v", topic, tm.Error)
+	}
 
-	for i := 0; i < k.MaxRetry; i++ {
-		producer, err = kafka.NewProducer(&kafka.ConfigMap{
-			"bootstrap.servers": k.Brokers,
-		})
+	if len(tm.Partitions) == 0 {
+		return fmt.Errorf("topic %s has no partitions", topic)
+	}
 
-		if err != nil {
-			fmt.Printf("Failed to create producer, retry %d/%d\n", i+1, k.MaxRetry)
-			time.Sleep(k.RetryWait)
-		} else {
-			break
+	var leaderless []string
+	for _, p := range tm.Partitions {
+		if p.Leader < 0 || p.Error.Code() == kafka.ErrLeaderNotAvailable {
+			leaderless = append(leaderless, fmt.Sprint(p.ID))
 		}
 	}
 
+	if len(leaderless) > 0 {
+		return fmt.Errorf("topic %s has no leader for partitions %s", topic, strings.Join(leaderless, ","))
+	}
+
+	return nil
+}
+
+func (k *KafkaClient) RegisterHealthCheck(registry *health.Registry, opts health.Options) error {
+	return registry.Register(health.Check{Name: "kafka", Checker: k, Options: opts})
+}
+
+// timeoutMs converts the remaining context deadline into the millisecond
+// timeout librdkafka expects.
+func timeoutMs(ctx context.Context, fallback int) int {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return fallback
+	}
+
+	ms := int(time.Until(deadline).Milliseconds())
+	if ms < 1 {
+		ms = 1
+	}
+	return ms
+}
+
+func (k *KafkaClient) Close() {
+	if k.admin != nil {
+		k.admin.Close()
+	}
+}
+
+func (k *KafkaClient) NewConsumer(ctx context.Context, topics []string, autoCommit bool) (*kafka.Consumer, error) {
+	return k.NewGroupConsumer(ctx, k.ConsumerGroup, topics, autoCommit)
+}
+
+// NewGroupConsumer subscribes a consumer of group to topics once the brokers
+// answer, waiting for them no longer than ctx allows.
+func (k *KafkaClient) NewGroupConsumer(ctx context.Context, group string, topics []string, autoCommit bool) (*kafka.Consumer, error) {
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  strings.Join(k.Brokers, ","),
+		"group.id":           group,
+		"auto.offset.reset":  "earliest",
+		"enable.auto.commit": autoCommit,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create producer after %d retries: %v", k.MaxRetry, err)
+		return nil, err
+	}
+
+	if err := k.awaitBrokers(ctx, "Connecting kafka consumer", consumer); err != nil {
+		consumer.Close()
+		return nil, err
+	}
+
+	if err := consumer.SubscribeTopics(topics, nil); err != nil {
+		consumer.Close()
+		return nil, err
+	}
+
+	fmt.Println("Consumer created and subscribed to topics:", topics)
+	return consumer, nil
+}
+
+// NewProducer creates a producer once the brokers answer, waiting for them
+// no longer than ctx allows.
+func (k *KafkaClient) NewProducer(ctx context.Context) (*kafka.Producer, error) {
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers": strings.Join(k.Brokers, ","),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := k.awaitBrokers(ctx, "Connecting kafka producer", producer); err != nil {
+		producer.Close()
+		return nil, err
 	}
 
 	fmt.Println("Producer created")
@@ -0,0 +1,135 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/health"
+)
+
+// broadcastGroupSuffix names the group.id librdkafka insists on. The
+// broadcast consumer never joins the group nor commits to it, so replicas
+// sharing it do not split the partitions.
+const broadcastGroupSuffix = "-broadcast"
+
+// KafkaBroadcast reads every partition of its topics from the newest offset,
+// so that each replica sees every message, which the group consumer only
+// does for the partitions it was assigned. Nothing is committed and a
+// failing handler is neither retried nor redelivered. Partitions added while
+// it runs are picked up after a restart.
+type KafkaBroadcast struct {
+	consumer *kafka.Consumer
+	topics   []string
+	mu       sync.RWMutex
+	handlers map[string][]MessageHandler
+	beat     *health.Beat
+}
+
+// NewKafkaBroadcast assigns every partition of topics once the brokers answer
+// and the topics exist, waiting no longer than the reconnect policy and ctx
+// allow.
+func (k *KafkaClient) NewKafkaBroadcast(ctx context.Context, topics []string) (*KafkaBroadcast, error) {
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":        strings.Join(k.Brokers, ","),
+		"group.id":                 k.ConsumerGroup + broadcastGroupSuffix,
+		"enable.auto.commit":       false,
+		"enable.auto.offset.store": false,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var partitions []kafka.TopicPartition
+	err = k.Reconnect.Retry(ctx, "Assigning kafka broadcast partitions", func() error {
+		// Fetch all topics, requesting a single topic may auto-create it.
+		metadata, err := consumer.GetMetadata(nil, true, timeoutMs(ctx, 5000))
+		if err != nil {
+			return err
+		}
+
+		partitions = partitions[:0]
+		for _, topic := range topics {
+			if err := checkTopic(metadata, topic); err != nil {
+				return err
+			}
+
+			for _, p := range metadata.Topics[topic].Partitions {
+				topic := topic
+				partitions = append(partitions, kafka.TopicPartition{Topic: &topic, Partition: p.ID, Offset: kafka.OffsetEnd})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		consumer.Close()
+		return nil, err
+	}
+
+	if err := consumer.Assign(partitions); err != nil {
+		consumer.Close()
+		return nil, fmt.Errorf("failed to assign kafka broadcast partitions: %v", err)
+	}
+
+	return &KafkaBroadcast{
+		consumer: consumer,
+		topics:   topics,
+		handlers: make(map[string][]MessageHandler),
+	}, nil
+}
+
+// SetHeartbeat makes Run beat b on every poll.
+func (b *KafkaBroadcast) SetHeartbeat(beat *health.Beat) {
+	b.beat = beat
+}
+
+func (b *KafkaBroadcast) Handle(topic string, handler MessageHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers[topic] = append(b.handlers[topic], handler)
+}
+
+// Run blocks until ctx is cancelled or the consumer hits a fatal error, and
+// closes the underlying consumer before returning.
+func (b *KafkaBroadcast) Run(ctx context.Context) error {
+	defer func() {
+		if err := b.consumer.Close(); err != nil {
+			log.Printf("Failed to close kafka broadcast consumer: %v", err)
+		}
+		log.Println("Kafka broadcast consumer stopped")
+	}()
+
+	log.Println("Kafka broadcast consumer started for topics:", b.topics)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		b.beat.Beat()
+
+		switch e := b.consumer.Poll(kafkaPollTimeoutMs).(type) {
+		case *kafka.Message:
+			msg := newKafkaMessage(e)
+
+			b.mu.RLock()
+			handlers := b.handlers[msg.Topic]
+			b.mu.RUnlock()
+
+			if err := runHandlers(ctx, handlers, msg); err != nil {
+				log.Printf("Broadcast handler failed for %s[%d]@%d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+			}
+		case kafka.Error:
+			if e.IsFatal() {
+				return fmt.Errorf("fatal kafka broadcast consumer error: %v", e)
+			}
+			log.Printf("Kafka broadcast consumer error: %v", e)
+		}
+	}
+}
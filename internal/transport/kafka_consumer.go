@@ -0,0 +1,350 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/health"
+)
+
+const kafkaPollTimeoutMs = 100
+
+// KafkaConsumer polls the subscribed topics and dispatches every message to
+// the handlers registered for its topic. Offsets are committed only once all
+// handlers succeeded. A failing message is retried in-process, then sent to
+// the dead-letter topic when one is configured, and otherwise rewound and
+// redelivered. Transient failures are always rewound, a dependency outage
+// must not empty the topic into its dead-letter topic.
+type KafkaConsumer struct {
+	consumer   *kafka.Consumer
+	topics     []string
+	retryWait  time.Duration
+	retries    int
+	backoff    time.Duration
+	deadLetter *KafkaProducer
+	mu         sync.RWMutex
+	handlers   map[string][]MessageHandler
+	lagEvery   time.Duration
+	maxLag     int64
+	lagMu      sync.RWMutex
+	lags       []PartitionLag
+	beat       *health.Beat
+}
+
+type PartitionLag struct {
+	Topic     string
+	Partition int32
+	Committed int64
+	High      int64
+	Lag       int64
+}
+
+func (k *KafkaClient) NewKafkaConsumer(ctx context.Context, topics []string) (*KafkaConsumer, error) {
+	consumer, err := k.NewConsumer(ctx, topics, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaConsumer{
+		consumer:  consumer,
+		topics:    topics,
+		retryWait: k.Reconnect.InitialInterval,
+		handlers:  make(map[string][]MessageHandler),
+	}, nil
+}
+
+// SetRetryPolicy makes the consumer retry a failing message up to retries
+// times, doubling backoff after every attempt.
+func (c *KafkaConsumer) SetRetryPolicy(retries int, backoff time.Duration) {
+	c.retries = retries
+	c.backoff = backoff
+}
+
+// SetDeadLetter publishes messages that exhausted their retries to
+// "<topic>.dlq" through producer.
+func (c *KafkaConsumer) SetDeadLetter(producer *KafkaProducer) {
+	c.deadLetter = producer
+}
+
+// SetLagMonitor makes Run compute the per-partition lag every interval. When
+// maxLag is positive, Check fails once any partition lags further behind.
+func (c *KafkaConsumer) SetLagMonitor(interval time.Duration, maxLag int64) {
+	c.lagEvery = interval
+	c.maxLag = maxLag
+}
+
+// SetHeartbeat makes Run beat b on every poll and handler attempt, so that a
+// wedged handler or poll shows up on the liveness probe.
+func (c *KafkaConsumer) SetHeartbeat(b *health.Beat) {
+	c.beat = b
+}
+
+func (c *KafkaConsumer) Handle(topic string, handler MessageHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handlers[topic] = append(c.handlers[topic], handler)
+}
+
+// Run blocks until ctx is cancelled or the consumer hits a fatal error, and
+// closes the underlying consumer before returning.
+func (c *KafkaConsumer) Run(ctx context.Context) error {
+	lagCtx, stopLag := context.WithCancel(ctx)
+	var lagDone sync.WaitGroup
+
+	if c.lagEvery > 0 {
+		lagDone.Add(1)
+		go func() {
+			defer lagDone.Done()
+			c.monitorLag(lagCtx)
+		}()
+	}
+
+	defer func() {
+		stopLag()
+		lagDone.Wait()
+
+		if err := c.consumer.Close(); err != nil {
+			log.Printf("Failed to close kafka consumer: %v", err)
+		}
+		log.Println("Kafka consumer stopped")
+	}()
+
+	log.Println("Kafka consumer started for topics:", c.topics)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		c.beat.Beat()
+
+		switch e := c.consumer.Poll(kafkaPollTimeoutMs).(type) {
+		case *kafka.Message:
+			c.dispatch(ctx, e)
+		case kafka.Error:
+			if e.IsFatal() {
+				return fmt.Errorf("fatal kafka consumer error: %v", e)
+			}
+			log.Printf("Kafka consumer error: %v", e)
+		}
+	}
+}
+
+func (c *KafkaConsumer) dispatch(ctx context.Context, m *kafka.Message) {
+	msg := newKafkaMessage(m)
+
+	attempts, err := c.handle(ctx, msg)
+	if err != nil {
+		log.Printf("Handler failed for %s[%d]@%d after %d attempts: %v", msg.Topic, msg.Partition, msg.Offset, attempts, err)
+
+		if c.deadLetter == nil || IsTransient(err) || ctx.Err() != nil {
+			c.rewind(ctx, m)
+			return
+		}
+
+		if err := publishDeadLetter(ctx, c.deadLetter, msg, err, attempts); err != nil {
+			log.Printf("Failed to dead-letter %s[%d]@%d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+			c.rewind(ctx, m)
+			return
+		}
+	}
+
+	if _, err := c.consumer.CommitMessage(m); err != nil {
+		log.Printf("Failed to commit %s[%d]@%d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+	}
+}
+
+// handle runs every handler registered for the topic, retrying the whole
+// chain with exponential backoff, and returns the number of attempts made.
+func (c *KafkaConsumer) handle(ctx context.Context, msg *Message) (int, error) {
+	c.mu.RLock()
+	handlers := c.handlers[msg.Topic]
+	c.mu.RUnlock()
+
+	var err error
+	wait := c.backoff
+
+	for attempt := 1; ; attempt++ {
+		c.beat.Beat()
+
+		err = runHandlers(ctx, handlers, msg)
+		if err == nil || attempt > c.retries {
+			return attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func runHandlers(ctx context.Context, handlers []MessageHandler, msg *Message) error {
+	for _, h := range handlers {
+		if err := h.HandleMessage(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewind seeks back to the failed message so that it is polled again after
+// the initial reconnect interval.
+func (c *KafkaConsumer) rewind(ctx context.Context, m *kafka.Message) {
+	if err := c.consumer.Seek(m.TopicPartition, 0); err != nil {
+		log.Printf("Failed to rewind %s: %v", m.TopicPartition, err)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(c.retryWait):
+	}
+}
+
+func newKafkaMessage(m *kafka.Message) *Message {
+	headers := make(map[string]string, len(m.Headers))
+	for _, h := range m.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+
+	var topic string
+	if m.TopicPartition.Topic != nil {
+		topic = *m.TopicPartition.Topic
+	}
+
+	return &Message{
+		Source:    "kafka",
+		Topic:     topic,
+		Key:       m.Key,
+		Value:     m.Value,
+		Headers:   headers,
+		Partition: m.TopicPartition.Partition,
+		Offset:    int64(m.TopicPartition.Offset),
+		Timestamp: m.Timestamp,
+	}
+}
+
+func (c *KafkaConsumer) monitorLag(ctx context.Context) {
+	ticker := time.NewTicker(c.lagEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			lags, err := c.computeLag(int(c.lagEvery.Milliseconds()))
+			if err != nil {
+				log.Printf("Failed to compute kafka consumer lag: %v", err)
+				continue
+			}
+
+			c.lagMu.Lock()
+			c.lags = lags
+			c.lagMu.Unlock()
+		}
+	}
+}
+
+// computeLag returns the high watermark minus the committed offset for every
+// assigned partition. Partitions without a committed offset count from the
+// low watermark, matching auto.offset.reset=earliest.
+func (c *KafkaConsumer) computeLag(timeoutMs int) ([]PartitionLag, error) {
+	assigned, err := c.consumer.Assignment()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(assigned) == 0 {
+		return nil, nil
+	}
+
+	committed, err := c.consumer.Committed(assigned, timeoutMs)
+	if err != nil {
+		return nil, err
+	}
+
+	lags := make([]PartitionLag, 0, len(committed))
+	for _, tp := range committed {
+		if tp.Topic == nil {
+			continue
+		}
+
+		low, high, err := c.consumer.QueryWatermarkOffsets(*tp.Topic, tp.Partition, timeoutMs)
+		if err != nil {
+			return nil, err
+		}
+
+		offset := int64(tp.Offset)
+		if offset < 0 {
+			offset = low
+		}
+
+		lag := high - offset
+		if lag < 0 {
+			lag = 0
+		}
+
+		lags = append(lags, PartitionLag{Topic: *tp.Topic, Partition: tp.Partition, Committed: offset, High: high, Lag: lag})
+	}
+
+	return lags, nil
+}
+
+// Lag returns the per-partition lag from the most recent computation.
+func (c *KafkaConsumer) Lag() []PartitionLag {
+	c.lagMu.RLock()
+	defer c.lagMu.RUnlock()
+
+	return append([]PartitionLag(nil), c.lags...)
+}
+
+func (c *KafkaConsumer) Check(ctx context.Context) error {
+	if c.maxLag <= 0 {
+		return nil
+	}
+
+	for _, l := range c.Lag() {
+		if l.Lag > c.maxLag {
+			return fmt.Errorf("partition %s[%d] lags %d messages behind, threshold is %d", l.Topic, l.Partition, l.Lag, c.maxLag)
+		}
+	}
+
+	return nil
+}
+
+func (c *KafkaConsumer) HealthDetails() map[string]interface{} {
+	lags := c.Lag()
+
+	partitions := make([]map[string]interface{}, 0, len(lags))
+	var total int64
+	for _, l := range lags {
+		total += l.Lag
+		partitions = append(partitions, map[string]interface{}{
+			"topic":     l.Topic,
+			"partition": l.Partition,
+			"committed": l.Committed,
+			"high":      l.High,
+			"lag":       l.Lag,
+		})
+	}
+
+	return map[string]interface{}{
+		"max_lag":    c.maxLag,
+		"total_lag":  total,
+		"partitions": partitions,
+	}
+}
+
+func (c *KafkaConsumer) RegisterHealthCheck(registry *health.Registry, opts health.Options) error {
+	return registry.Register(health.Check{Name: "kafka-lag", Checker: c, Options: opts})
+}
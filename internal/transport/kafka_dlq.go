@@ -0,0 +1,126 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+const (
+	DeadLetterSuffix = ".dlq"
+
+	HeaderDLQError           = "x-dlq-error"
+	HeaderDLQAttempts        = "x-dlq-attempts"
+	HeaderDLQSourceTopic     = "x-dlq-source-topic"
+	HeaderDLQSourcePartition = "x-dlq-source-partition"
+	HeaderDLQSourceOffset    = "x-dlq-source-offset"
+
+	// replayIdleTimeout ends a replay once the dead-letter topic has been
+	// drained. It only starts counting once partitions are assigned.
+	replayIdleTimeout = 3 * time.Second
+
+	// replayJoinTimeout bounds how long the replay group may take to get
+	// partitions, the broker delays the first rebalance of a new group.
+	replayJoinTimeout = 30 * time.Second
+)
+
+func DeadLetterTopic(topic string) string {
+	return topic + DeadLetterSuffix
+}
+
+// publishDeadLetter sends the original payload to "<topic>.dlq" together
+// with the failure and its origin, and waits for the broker to accept it.
+func publishDeadLetter(ctx context.Context, producer *KafkaProducer, msg *Message, cause error, attempts int) error {
+	headers := make(map[string]string, len(msg.Headers)+5)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+
+	headers[HeaderDLQError] = cause.Error()
+	headers[HeaderDLQAttempts] = strconv.Itoa(attempts)
+	headers[HeaderDLQSourceTopic] = msg.Topic
+	headers[HeaderDLQSourcePartition] = strconv.Itoa(int(msg.Partition))
+	headers[HeaderDLQSourceOffset] = strconv.FormatInt(msg.Offset, 10)
+
+	_, err := producer.Publish(ctx, DeadLetterTopic(msg.Topic), msg.Key, msg.Value, headers)
+	return err
+}
+
+// ReplayDeadLetters moves up to limit messages from the dead-letter topic of
+// topic back to their source topic, stripping the dead-letter headers. It
+// stops early once the dead-letter topic has been idle for a few seconds and
+// returns the number of replayed messages. The whole replay is bounded by
+// ctx.
+func (k *KafkaClient) ReplayDeadLetters(ctx context.Context, producer *KafkaProducer, topic string, limit int) (int, error) {
+	dlq := DeadLetterTopic(topic)
+
+	consumer, err := k.NewGroupConsumer(ctx, k.ConsumerGroup+"-dlq-replay", []string{dlq}, false)
+	if err != nil {
+		return 0, err
+	}
+	defer consumer.Close()
+
+	replayed := 0
+	assigned := false
+	joinDeadline := time.Now().Add(replayJoinTimeout)
+	var idleSince time.Time
+
+	for replayed < limit && (!assigned || time.Since(idleSince) < replayIdleTimeout) {
+		if ctx.Err() != nil {
+			return replayed, ctx.Err()
+		}
+
+		// The idle timer must not run while the new group is still joining,
+		// the join alone usually takes longer than replayIdleTimeout.
+		if !assigned {
+			if partitions, err := consumer.Assignment(); err == nil && len(partitions) > 0 {
+				assigned = true
+				idleSince = time.Now()
+			} else if time.Now().After(joinDeadline) {
+				return replayed, fmt.Errorf("no partitions of %s assigned within %v", dlq, replayJoinTimeout)
+			}
+		}
+
+		switch e := consumer.Poll(kafkaPollTimeoutMs).(type) {
+		case *kafka.Message:
+			assigned = true
+			idleSince = time.Now()
+
+			msg := newKafkaMessage(e)
+			source := msg.Headers[HeaderDLQSourceTopic]
+			if source == "" {
+				source = strings.TrimSuffix(msg.Topic, DeadLetterSuffix)
+			}
+
+			headers := make(map[string]string, len(msg.Headers))
+			for k, v := range msg.Headers {
+				if !strings.HasPrefix(k, "x-dlq-") {
+					headers[k] = v
+				}
+			}
+
+			if _, err := producer.Publish(ctx, source, msg.Key, msg.Value, headers); err != nil {
+				return replayed, fmt.Errorf("failed to replay %s[%d]@%d to %s: %v", msg.Topic, msg.Partition, msg.Offset, source, err)
+			}
+
+			if _, err := consumer.CommitMessage(e); err != nil {
+				return replayed, fmt.Errorf("failed to commit %s[%d]@%d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+			}
+
+			replayed++
+		case kafka.Error:
+			if e.IsFatal() {
+				return replayed, fmt.Errorf("fatal kafka consumer error: %v", e)
+			}
+			log.Printf("Kafka replay consumer error: %v", e)
+		}
+	}
+
+	log.Printf("Replayed %d messages from %s", replayed, dlq)
+	return replayed, nil
+}
@@ -0,0 +1,165 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+var ErrProducerClosed = errors.New("kafka producer is closed")
+
+type DeliveryReport struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Err       error
+}
+
+type DeliveryCallback func(report DeliveryReport)
+
+// KafkaProducer drains the delivery reports of the underlying producer and
+// routes each one to the callback of the message it belongs to.
+type KafkaProducer struct {
+	producer     *kafka.Producer
+	defaultTopic string
+	inFlight     int64
+	done         chan struct{}
+	mu           sync.RWMutex
+	closed       bool
+}
+
+func (k *KafkaClient) NewKafkaProducer(ctx context.Context, defaultTopic string) (*KafkaProducer, error) {
+	producer, err := k.NewProducer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &KafkaProducer{
+		producer:     producer,
+		defaultTopic: defaultTopic,
+		done:         make(chan struct{}),
+	}
+
+	go p.handleEvents()
+
+	return p, nil
+}
+
+// Publish produces a message and waits for its delivery report. An empty
+// topic publishes to the default topic.
+func (p *KafkaProducer) Publish(ctx context.Context, topic string, key, value []byte, headers map[string]string) (DeliveryReport, error) {
+	reports := make(chan DeliveryReport, 1)
+
+	err := p.PublishAsync(topic, key, value, headers, func(report DeliveryReport) {
+		reports <- report
+	})
+	if err != nil {
+		return DeliveryReport{}, err
+	}
+
+	select {
+	case report := <-reports:
+		return report, report.Err
+	case <-ctx.Done():
+		return DeliveryReport{}, fmt.Errorf("waiting for delivery report: %v", ctx.Err())
+	}
+}
+
+// PublishAsync enqueues a message and returns immediately, callback is
+// invoked from the event loop once the broker acknowledged or rejected it.
+// It fails with ErrProducerClosed once Close has been called.
+func (p *KafkaProducer) PublishAsync(topic string, key, value []byte, headers map[string]string, callback DeliveryCallback) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrProducerClosed
+	}
+
+	if topic == "" {
+		topic = p.defaultTopic
+	}
+
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            key,
+		Value:          value,
+		Opaque:         callback,
+	}
+
+	for k, v := range headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	atomic.AddInt64(&p.inFlight, 1)
+
+	if err := p.producer.Produce(msg, nil); err != nil {
+		atomic.AddInt64(&p.inFlight, -1)
+		return fmt.Errorf("failed to produce to %s: %v", topic, err)
+	}
+
+	return nil
+}
+
+// InFlight returns the number of messages awaiting a delivery report.
+func (p *KafkaProducer) InFlight() int64 {
+	return atomic.LoadInt64(&p.inFlight)
+}
+
+func (p *KafkaProducer) handleEvents() {
+	defer close(p.done)
+
+	for e := range p.producer.Events() {
+		switch ev := e.(type) {
+		case *kafka.Message:
+			atomic.AddInt64(&p.inFlight, -1)
+
+			report := DeliveryReport{
+				Partition: ev.TopicPartition.Partition,
+				Offset:    int64(ev.TopicPartition.Offset),
+				Err:       ev.TopicPartition.Error,
+			}
+			if ev.TopicPartition.Topic != nil {
+				report.Topic = *ev.TopicPartition.Topic
+			}
+
+			if callback, ok := ev.Opaque.(DeliveryCallback); ok && callback != nil {
+				callback(report)
+			} else if report.Err != nil {
+				log.Printf("Delivery to %s failed: %v", report.Topic, report.Err)
+			}
+		case kafka.Error:
+			log.Printf("Kafka producer error: %v", ev)
+		}
+	}
+}
+
+// Close rejects new messages, flushes outstanding ones until ctx expires and
+// then closes the producer. Messages still in flight at the deadline are
+// reported as lost.
+func (p *KafkaProducer) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	remaining := p.producer.Flush(timeoutMs(ctx, 5000))
+
+	p.producer.Close()
+	<-p.done
+
+	if remaining > 0 {
+		return fmt.Errorf("%d kafka messages were not delivered before shutdown", remaining)
+	}
+
+	log.Println("Kafka producer flushed and closed")
+	return nil
+}
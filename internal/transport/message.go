@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Message is the transport-neutral envelope handed to stream handlers, no
+// matter which pipeline it was consumed from.
+type Message struct {
+	Source    string
+	Topic     string
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+}
+
+type MessageHandler interface {
+	HandleMessage(ctx context.Context, msg *Message) error
+}
+
+// MessageHandlerFunc adapts a plain function to the MessageHandler interface.
+type MessageHandlerFunc func(ctx context.Context, msg *Message) error
+
+func (f MessageHandlerFunc) HandleMessage(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// Transient marks err as caused by an unavailable dependency rather than by
+// the message. Consumers redeliver such messages instead of dead-lettering
+// them, since any other message would fail the same way.
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return transientError{err}
+}
+
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e transientError) Unwrap() error {
+	return e.err
+}
+
+// IsTransient reports whether err, or an error it wraps, was marked with
+// Transient.
+func IsTransient(err error) bool {
+	var t transientError
+	return errors.As(err, &t)
+}
@@ -0,0 +1,37 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIsTransient(t *testing.T) {
+	cause := errors.New("connection refused")
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain", cause, false},
+		{"marked", Transient(cause), true},
+		{"wrapped", fmt.Errorf("handler: %w", Transient(cause)), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.want {
+				t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+
+	if Transient(nil) != nil {
+		t.Error("Transient(nil) is not nil")
+	}
+	if !errors.Is(Transient(cause), cause) {
+		t.Error("Transient hides the cause from errors.Is")
+	}
+}
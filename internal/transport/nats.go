@@ -1,11 +1,14 @@
 package transport
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/health"
 	"github.com/nats-io/nats.go"
 )
 
@@ -13,6 +16,16 @@ type NatsClient struct {
 	nc      *nats.Conn
 	wg      sync.WaitGroup
 	subject string
+	mu      sync.RWMutex
+	rtt     time.Duration
+	closed  chan struct{}
+}
+
+type NatsStatus struct {
+	State        string
+	ConnectedURL string
+	Reconnects   uint64
+	RTT          time.Duration
 }
 
 /*
@@ -20,8 +33,10 @@ nm := NewNatsClient([]string{"nats://nats1:4222", "nats://nats2:4222", "nats://n
 */
 
 func NewNatsClient(servers []string) (*NatsClient, error) {
+	closed := make(chan struct{})
+
 	opts := []nats.Option{nats.Name("NATS Manager")}
-	opts = setupConnOptions(opts)
+	opts = setupConnOptions(opts, closed)
 
 	serversStr := strings.Join(servers, ",")
 
@@ -31,15 +46,15 @@ func NewNatsClient(servers []string) (*NatsClient, error) {
 		return nil, err
 	}
 
-	return &NatsClient{nc: nc}, nil
+	return &NatsClient{nc: nc, closed: closed}, nil
 }
 
 func (nm *NatsClient) Subscribe(subject string, handler nats.MsgHandler) (*nats.Subscription, error) {
-	nm.wg.Add(1)
-
 	sub, err := nm.nc.Subscribe(subject, func(m *nats.Msg) {
+		nm.wg.Add(1)
+		defer nm.wg.Done()
+
 		handler(m)
-		nm.wg.Done()
 	})
 
 	if err != nil {
@@ -49,21 +64,133 @@ func (nm *NatsClient) Subscribe(subject string, handler nats.MsgHandler) (*nats.
 	return sub, nil
 }
 
+// Handle subscribes handler to subject, wrapping every NATS message into the
+// transport-neutral Message.
+func (nm *NatsClient) Handle(subject string, handler MessageHandler) error {
+	_, err := nm.Subscribe(subject, func(m *nats.Msg) {
+		headers := make(map[string]string, len(m.Header))
+		for k := range m.Header {
+			headers[k] = m.Header.Get(k)
+		}
+
+		msg := &Message{
+			Source:    "nats",
+			Topic:     m.Subject,
+			Value:     m.Data,
+			Headers:   headers,
+			Timestamp: time.Now(),
+		}
+
+		if err := handler.HandleMessage(context.Background(), msg); err != nil {
+			log.Printf("NATS message handler failed for %s: %v", m.Subject, err)
+		}
+	})
+	return err
+}
+
 func (nm *NatsClient) Publish(subject string, data []byte) error {
 	return nm.nc.Publish(subject, data)
 }
 
-func (nm *NatsClient) Close() {
-	// Gracefully close the connection
-	nm.nc.Drain()
+func (nm *NatsClient) IsConnected() bool {
+	return nm.nc.IsConnected()
+}
+
+// Status reports the connection state along with the RTT measured by the
+// most recent health check.
+func (nm *NatsClient) Status() NatsStatus {
+	nm.mu.RLock()
+	rtt := nm.rtt
+	nm.mu.RUnlock()
+
+	return NatsStatus{
+		State:        natsState(nm.nc.Status()),
+		ConnectedURL: nm.nc.ConnectedUrlRedacted(),
+		Reconnects:   nm.nc.Stats().Reconnects,
+		RTT:          rtt,
+	}
+}
+
+func (nm *NatsClient) Check(ctx context.Context) error {
+	if status := nm.nc.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("nats connection is %s", natsState(status))
+	}
+
+	start := time.Now()
+	if err := nm.nc.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("nats flush failed: %v", err)
+	}
+
+	nm.mu.Lock()
+	nm.rtt = time.Since(start)
+	nm.mu.Unlock()
+
+	return nil
+}
+
+func (nm *NatsClient) HealthDetails() map[string]interface{} {
+	status := nm.Status()
+	return map[string]interface{}{
+		"state":         status.State,
+		"connected_url": status.ConnectedURL,
+		"reconnects":    status.Reconnects,
+		"rtt_ms":        float64(status.RTT.Microseconds()) / 1000,
+	}
+}
+
+func (nm *NatsClient) RegisterHealthCheck(registry *health.Registry, opts health.Options) error {
+	return registry.Register(health.Check{Name: "nats", Checker: nm, Options: opts})
+}
+
+// Close drains the connection, which lets pending messages reach their
+// handlers before it closes on its own. When ctx is done first the
+// connection is closed right away and the remaining messages are dropped.
+func (nm *NatsClient) Close(ctx context.Context) error {
+	defer nm.nc.Close()
+
+	if err := nm.nc.Drain(); err == nil {
+		select {
+		case <-nm.closed:
+		case <-ctx.Done():
+			return fmt.Errorf("nats drain did not finish in time: %v", ctx.Err())
+		}
+	}
 
 	// Wait for all subscriptions to finish
-	nm.wg.Wait()
+	done := make(chan struct{})
+	go func() {
+		nm.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("nats handlers did not finish in time: %v", ctx.Err())
+	}
+}
 
-	nm.nc.Close()
+func natsState(status nats.Status) string {
+	switch status {
+	case nats.CONNECTED:
+		return "connected"
+	case nats.RECONNECTING:
+		return "reconnecting"
+	case nats.CLOSED:
+		return "closed"
+	case nats.DRAINING_SUBS, nats.DRAINING_PUBS:
+		return "draining"
+	case nats.CONNECTING:
+		return "connecting"
+	default:
+		return "disconnected"
+	}
 }
 
-func setupConnOptions(opts []nats.Option) []nats.Option {
+// setupConnOptions configures reconnection and closes closed once the
+// connection is closed for good.
+func setupConnOptions(opts []nats.Option, closed chan struct{}) []nats.Option {
 	totalWait := 10 * time.Minute
 	reconnectDelay := time.Second
 
@@ -75,8 +202,10 @@ func setupConnOptions(opts []nats.Option) []nats.Option {
 	opts = append(opts, nats.ReconnectHandler(func(nc *nats.Conn) {
 		log.Printf("Got reconnected to %v!\n", nc.ConnectedUrl())
 	}))
+	var once sync.Once
 	opts = append(opts, nats.ClosedHandler(func(nc *nats.Conn) {
 		log.Printf("Connection closed. Reason: %q\n", nc.LastError())
+		once.Do(func() { close(closed) })
 	}))
 	return opts
 }
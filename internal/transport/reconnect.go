@@ -0,0 +1,196 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/config"
+)
+
+// ReconnectPolicy decides how long to wait between connection attempts. The
+// delay grows by Multiplier from InitialInterval up to MaxInterval, and Jitter
+// spreads it by that fraction in either direction so that replicas do not
+// reconnect in lockstep. MaxAttempts of zero retries forever.
+type ReconnectPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	Jitter          float64
+	MaxAttempts     int
+}
+
+var DefaultReconnectPolicy = ReconnectPolicy{
+	InitialInterval: 500 * time.Millisecond,
+	MaxInterval:     30 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+}
+
+// NewReconnectPolicy reads the RECONNECT_* settings, falling back to
+// DefaultReconnectPolicy for unset values.
+func NewReconnectPolicy(cnf *config.Config) ReconnectPolicy {
+	p := DefaultReconnectPolicy
+	if cnf.ReconnectInterval > 0 {
+		p.InitialInterval = time.Duration(cnf.ReconnectInterval) * time.Millisecond
+	}
+	if cnf.ReconnectMaxInterval > 0 {
+		p.MaxInterval = time.Duration(cnf.ReconnectMaxInterval) * time.Millisecond
+	}
+	if cnf.ReconnectMultiplier >= 1 {
+		p.Multiplier = cnf.ReconnectMultiplier
+	}
+	if cnf.ReconnectJitter >= 0 && cnf.ReconnectJitter <= 1 {
+		p.Jitter = cnf.ReconnectJitter
+	}
+	if cnf.ReconnectMaxAttempts > 0 {
+		p.MaxAttempts = cnf.ReconnectMaxAttempts
+	}
+	return p
+}
+
+// Delay returns the wait after the given failed attempt, counted from 1.
+func (p ReconnectPolicy) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := float64(p.InitialInterval) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxInterval > 0 && delay > float64(p.MaxInterval) {
+		delay = float64(p.MaxInterval)
+	}
+	return p.jitter(delay)
+}
+
+// ProbeDelay returns the wait between the attempts that keep probing a
+// dependency once the policy is exhausted.
+func (p ReconnectPolicy) ProbeDelay() time.Duration {
+	delay := p.MaxInterval
+	if delay <= 0 {
+		delay = p.InitialInterval
+	}
+	return p.jitter(float64(delay))
+}
+
+func (p ReconnectPolicy) jitter(delay float64) time.Duration {
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+// Exhausted reports whether no attempt may follow the given one.
+func (p ReconnectPolicy) Exhausted(attempt int) bool {
+	return p.MaxAttempts > 0 && attempt >= p.MaxAttempts
+}
+
+// Retry calls fn until it succeeds, the attempts are exhausted or ctx is done.
+func (p ReconnectPolicy) Retry(ctx context.Context, name string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if p.Exhausted(attempt) {
+			return fmt.Errorf("%s failed after %d attempts: %v", name, attempt, err)
+		}
+
+		wait := p.Delay(attempt)
+		log.Printf("%s failed, attempt %d, retrying in %v: %v", name, attempt, wait.Round(time.Millisecond), err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s cancelled after %d attempts: %v", name, attempt, err)
+		case <-time.After(wait):
+		}
+	}
+}
+
+const (
+	StateConnected    = "connected"
+	StateReconnecting = "reconnecting"
+	StateFailed       = "failed"
+)
+
+// connectionState records the progress of a reconnect loop so that health
+// checks can report it.
+type connectionState struct {
+	mu       sync.RWMutex
+	state    string
+	attempts int
+	lastErr  error
+	since    time.Time
+}
+
+func (s *connectionState) connected() {
+	s.set(StateConnected, nil)
+}
+
+// failed records a failed attempt and returns how many failed in a row. A
+// loop that gave up stays failed while it keeps probing.
+func (s *connectionState) failed(err error) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != StateReconnecting && s.state != StateFailed {
+		s.state = StateReconnecting
+		s.since = time.Now()
+	}
+	s.attempts++
+	s.lastErr = err
+	return s.attempts
+}
+
+func (s *connectionState) giveUp(err error) {
+	s.set(StateFailed, err)
+}
+
+func (s *connectionState) set(state string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != state {
+		s.since = time.Now()
+	}
+	s.state = state
+	s.lastErr = err
+	if state == StateConnected {
+		s.attempts = 0
+	}
+}
+
+func (s *connectionState) isConnected() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state == StateConnected
+}
+
+// err is non-nil once the reconnect loop has given up, until it reconnects.
+func (s *connectionState) err() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.state != StateFailed {
+		return nil
+	}
+	return fmt.Errorf("reconnection gave up after %d attempts: %v", s.attempts, s.lastErr)
+}
+
+func (s *connectionState) details(details map[string]interface{}) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.state == "" {
+		return
+	}
+	details["state"] = s.state
+	details["reconnect_attempts"] = s.attempts
+	if !s.since.IsZero() {
+		details["state_since"] = s.since.UTC()
+	}
+}
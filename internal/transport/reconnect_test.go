@@ -0,0 +1,108 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconnectPolicyDelay(t *testing.T) {
+	policy := ReconnectPolicy{
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     time.Second,
+		Multiplier:      2,
+	}
+
+	tests := []struct {
+		name    string
+		attempt int
+		want    time.Duration
+	}{
+		{"attempt below one counts as the first", 0, 100 * time.Millisecond},
+		{"first attempt", 1, 100 * time.Millisecond},
+		{"grows by the multiplier", 3, 400 * time.Millisecond},
+		{"last step below the maximum", 4, 800 * time.Millisecond},
+		{"clamped to the maximum", 5, time.Second},
+		{"stays clamped", 100, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Delay(tt.attempt); got != tt.want {
+				t.Errorf("Delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconnectPolicyDelayJitter(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		base    time.Duration
+	}{
+		{"first attempt", 1, 100 * time.Millisecond},
+		{"clamped attempt", 10, time.Second},
+	}
+
+	policy := ReconnectPolicy{
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			min := time.Duration(float64(tt.base) * 0.8)
+			max := time.Duration(float64(tt.base) * 1.2)
+
+			for i := 0; i < 1000; i++ {
+				if got := policy.Delay(tt.attempt); got < min || got > max {
+					t.Fatalf("Delay(%d) = %v, want within [%v, %v]", tt.attempt, got, min, max)
+				}
+			}
+		})
+	}
+}
+
+func TestReconnectPolicyProbeDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ReconnectPolicy
+		want   time.Duration
+	}{
+		{"maximum interval", ReconnectPolicy{InitialInterval: time.Second, MaxInterval: 30 * time.Second}, 30 * time.Second},
+		{"initial interval without a maximum", ReconnectPolicy{InitialInterval: time.Second}, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.ProbeDelay(); got != tt.want {
+				t.Errorf("ProbeDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconnectPolicyExhausted(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts int
+		attempt     int
+		want        bool
+	}{
+		{"unlimited", 0, 1000, false},
+		{"attempts left", 3, 2, false},
+		{"last attempt", 3, 3, true},
+		{"past the last attempt", 3, 4, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := ReconnectPolicy{MaxAttempts: tt.maxAttempts}
+			if got := policy.Exhausted(tt.attempt); got != tt.want {
+				t.Errorf("Exhausted(%d) with MaxAttempts %d = %v, want %v", tt.attempt, tt.maxAttempts, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,30 +2,54 @@ package transport
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/config"
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/health"
 	"github.com/go-redis/redis/v8"
 )
 
-var ctx = context.Background()
+var ErrKeyNotFound = errors.New("key not found")
 
 type RedisClient struct {
-	Client           *redis.Client
-	config           *config.Config
-	connectionStatus bool
+	Client    redis.UniversalClient
+	config    *config.Config
+	opTimeout time.Duration
+	codec     Codec
+	mode      string
+	topology  redisTopology
+	sentinels []*redis.SentinelClient
+	reconnect ReconnectPolicy
+	state     connectionState
 }
 
+// redisHeartbeatInterval is how often MonitorConnection pings a healthy
+// connection.
+const redisHeartbeatInterval = time.Second
+
+// NewRedisClient connects in the mode selected by REDIS_MODE. Standalone
+// mode dials redisURL unless REDIS_ADDRS is set, Sentinel and Cluster mode
+// take their seed addresses from REDIS_ADDRS.
 func NewRedisClient(redisURL string, cnf *config.Config) (*RedisClient, error) {
-	options, err := redis.ParseURL(redisURL)
+	codec, err := CodecByName(cnf.RedisCodec)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse Redis URL: %v", err)
+		return nil, err
 	}
 
-	client := redis.NewClient(options)
+	// The service caches and records untyped values, which protobuf cannot
+	// encode. Protobuf values go through SetWith and GetAsWith instead.
+	if _, ok := codec.(ProtobufCodec); ok {
+		return nil, fmt.Errorf("redis codec %q cannot be used for the whole client, use json or msgpack", codec.Name())
+	}
+
+	mode, client, err := newUniversalClient(redisURL, cnf)
+	if err != nil {
+		return nil, err
+	}
 
 	_, err = client.Ping(context.Background()).Result()
 	if err != nil {
@@ -33,10 +57,17 @@ func NewRedisClient(redisURL string, cnf *config.Config) (*RedisClient, error) {
 	}
 
 	redisClient := &RedisClient{
-		Client:           client,
-		connectionStatus: true,
-		config:           cnf,
+		Client:    client,
+		mode:      mode,
+		config:    cnf,
+		opTimeout: time.Duration(cnf.RedisOpTimeout) * time.Millisecond,
+		codec:     codec,
+		reconnect: NewReconnectPolicy(cnf),
+	}
+	if mode == RedisSentinel {
+		redisClient.sentinels = newSentinelClients(cnf)
 	}
+	redisClient.state.connected()
 
 	go redisClient.MonitorConnection()
 
@@ -44,73 +75,84 @@ func NewRedisClient(redisURL string, cnf *config.Config) (*RedisClient, error) {
 }
 
 func (r *RedisClient) IsConnected() bool {
-	return r.connectionStatus
+	return r.state.isConnected()
+}
+
+// withTimeout applies the default operation timeout unless the caller
+// already set a deadline.
+func (r *RedisClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || r.opTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.opTimeout)
+}
+
+func (r *RedisClient) RegisterHealthCheck(registry *health.Registry, opts health.Options) error {
+	return registry.Register(health.Check{Name: "redis", Checker: r, Options: opts})
 }
 
 func (r *RedisClient) hearthbeat() error {
-	_, err := r.Client.Ping(context.Background()).Result()
+	ctx, cancel := r.withTimeout(context.Background())
+	defer cancel()
+
+	_, err := r.Client.Ping(ctx).Result()
 	if err != nil {
-		r.connectionStatus = false
 		return fmt.Errorf("failed to connect to Redis: %v", err)
 	}
-
-	r.connectionStatus = true
 	return nil
 }
 
+// MonitorConnection pings Redis and, while it is unreachable, backs off
+// according to the reconnect policy. go-redis redials on its own, the loop
+// only paces the probing and records the state for the health check. When
+// the policy runs out of attempts the monitor stops and the health check
+// keeps failing, it never exits the process.
 func (r *RedisClient) MonitorConnection() {
-	log.Println("Entered Redis MonitorConnection", r.connectionStatus)
-
-	retries := 0
 	for {
-		waitTime := time.Duration(retries*r.config.MaxWait) * time.Second
-
-		time.Sleep(waitTime)
-
 		err := r.hearthbeat()
-		if err != nil {
-			log.Printf("Reconnection to Redis failed: %v", err)
-			retries++
-			if retries >= r.config.MaxRetry {
-				log.Fatal("Max retries reached for Redis connection. Exiting.")
-				return
-			}
-		} else {
-			if retries > 0 {
+		if err == nil {
+			if !r.state.isConnected() {
 				log.Println("Re-connected to Redis successfully")
+				r.state.connected()
 			}
-			retries = 0
+			time.Sleep(redisHeartbeatInterval)
+			continue
 		}
-	}
-}
 
-func (r *RedisClient) SetKeyValue(key string, value interface{}, expiration ...time.Duration) error {
-	jsonValue, err := json.Marshal(value)
-	if err != nil {
-		return err
-	}
-
-	expire := time.Duration(0) // Default no expiration
-	if len(expiration) > 0 {
-		expire = expiration[0]
-	}
+		attempt := r.state.failed(err)
+		if r.reconnect.Exhausted(attempt) {
+			if r.state.err() == nil {
+				log.Printf("Giving up on Redis after %d attempts, probing every %v: %v", attempt, r.reconnect.MaxInterval, err)
+				r.state.giveUp(err)
+			}
+			time.Sleep(r.reconnect.ProbeDelay())
+			continue
+		}
 
-	err = r.Client.Set(ctx, key, jsonValue, expire).Err()
-	if err != nil {
-		return err
+		wait := r.reconnect.Delay(attempt)
+		log.Printf("Reconnection to Redis failed, attempt %d, retrying in %v: %v", attempt, wait.Round(time.Millisecond), err)
+		time.Sleep(wait)
 	}
+}
 
-	return nil
+func (r *RedisClient) SetKeyValue(ctx context.Context, key string, value interface{}, expiration ...time.Duration) error {
+	return SetWith(ctx, r, r.codec, key, value, expiration...)
 }
 
-func (r *RedisClient) GetKeyValue(key string) (interface{}, error) {
+func (r *RedisClient) GetKeyValue(ctx context.Context, key string) (interface{}, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
 	val, err := r.Client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil, ErrKeyNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	var data interface{}
-	err = json.Unmarshal([]byte(val), &data)
+	err = r.codec.Unmarshal([]byte(val), &data)
 	if err != nil {
 		return nil, err
 	}
@@ -118,13 +160,15 @@ func (r *RedisClient) GetKeyValue(key string) (interface{}, error) {
 	return data, nil
 }
 
-func (r *RedisClient) PushList(key string, value interface{}) error {
-	jsonValue, err := json.Marshal(value)
-	if err != nil {
-		return err
-	}
+func (r *RedisClient) PushList(ctx context.Context, key string, value interface{}) error {
+	return PushWith(ctx, r, r.codec, key, value)
+}
+
+func (r *RedisClient) TrimList(ctx context.Context, key string, start, stop int64) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-	err = r.Client.LPush(ctx, key, jsonValue).Err()
+	err := r.Client.LTrim(ctx, key, start, stop).Err()
 	if err != nil {
 		return err
 	}
@@ -132,16 +176,17 @@ func (r *RedisClient) PushList(key string, value interface{}) error {
 	return nil
 }
 
-func (r *RedisClient) TrimList(key string, start, stop int64) error {
-	err := r.Client.LTrim(ctx, key, start, stop).Err()
-	if err != nil {
-		return err
-	}
+func (r *RedisClient) Increment(ctx context.Context, key string) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-	return nil
+	return r.Client.Incr(ctx, key).Result()
 }
 
-func (r *RedisClient) DeleteKey(key string) error {
+func (r *RedisClient) DeleteKey(ctx context.Context, key string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
 	err := r.Client.Del(ctx, key).Err()
 	if err != nil {
 		return err
@@ -150,7 +195,10 @@ func (r *RedisClient) DeleteKey(key string) error {
 	return nil
 }
 
-func (r *RedisClient) GetList(key string, start, stop int64) ([]interface{}, error) {
+func (r *RedisClient) GetList(ctx context.Context, key string, start, stop int64) ([]interface{}, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
 	vals, err := r.Client.LRange(ctx, key, start, stop).Result()
 	if err != nil {
 		return nil, err
@@ -159,7 +207,7 @@ func (r *RedisClient) GetList(key string, start, stop int64) ([]interface{}, err
 	var results []interface{}
 	for _, val := range vals {
 		var data interface{}
-		err = json.Unmarshal([]byte(val), &data)
+		err = r.codec.Unmarshal([]byte(val), &data)
 		if err != nil {
 			return nil, err
 		}
@@ -170,27 +218,106 @@ func (r *RedisClient) GetList(key string, start, stop int64) ([]interface{}, err
 	return results, nil
 }
 
-func (r *RedisClient) GetAll(prefix string) (map[string]interface{}, error) {
-	keys, err := r.Client.Keys(ctx, prefix+"*").Result()
+// DefaultScanBatch is the SCAN COUNT hint and the number of keys fetched
+// per MGET.
+const DefaultScanBatch = 100
+
+// GetAll returns the values of every key starting with prefix. It walks the
+// keyspace with SCAN instead of blocking Redis with KEYS, but the result is
+// unbounded: every matching value is held in memory at once. Use ScanValues
+// where the prefix may cover many keys.
+func (r *RedisClient) GetAll(ctx context.Context, prefix string) (map[string]interface{}, error) {
+	results := make(map[string]interface{})
+
+	err := r.ScanValues(ctx, prefix, DefaultScanBatch, func(key string, value interface{}) error {
+		results[key] = value
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	results := make(map[string]interface{})
-	for _, key := range keys {
-		val, err := r.Client.Get(ctx, key).Result()
-		if err != nil {
-			return nil, err
+	return results, nil
+}
+
+// ScanValues calls fn for every key starting with prefix, fetching values in
+// MGET batches of batch keys. SCAN may return a key more than once, and keys
+// deleted or holding a non-string type while scanning are skipped. Iteration
+// stops at the first error returned by fn or when ctx is done. In Cluster
+// mode every master is scanned in turn.
+func (r *RedisClient) ScanValues(ctx context.Context, prefix string, batch int64, fn func(key string, value interface{}) error) error {
+	if batch <= 0 {
+		batch = DefaultScanBatch
+	}
+
+	keys := make([]string, 0, batch)
+
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
 		}
 
-		var data interface{}
-		err = json.Unmarshal([]byte(val), &data)
+		opCtx, cancel := r.withTimeout(ctx)
+		defer cancel()
+
+		vals, err := r.mget(opCtx, keys...)
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		for i, val := range vals {
+			raw, ok := val.(string)
+			if !ok {
+				continue
+			}
+
+			var data interface{}
+			if err := r.codec.Unmarshal([]byte(raw), &data); err != nil {
+				return fmt.Errorf("failed to decode %s: %v", keys[i], err)
+			}
+
+			if err := fn(keys[i], data); err != nil {
+				return err
+			}
 		}
 
-		results[key] = data
+		keys = keys[:0]
+		return nil
 	}
 
-	return results, nil
+	nodes, err := r.scanNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		iter := node.Scan(ctx, 0, escapeGlob(prefix)+"*", batch).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+			if int64(len(keys)) >= batch {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+
+	return flush()
+}
+
+// escapeGlob makes prefix match literally in a SCAN MATCH pattern.
+func escapeGlob(prefix string) string {
+	var b strings.Builder
+	for _, c := range prefix {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
 }
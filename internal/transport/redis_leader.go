@@ -0,0 +1,254 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/health"
+	"github.com/go-redis/redis/v8"
+)
+
+// HeaderLeaderToken carries the fencing token of the leader that produced a
+// message, so that consumers can drop writes of a deposed leader.
+const HeaderLeaderToken = "x-leader-token"
+
+// acquireScript takes the lease when it is free and stamps it with a new
+// fencing token. Both keys share a hash tag, so it also runs on a cluster.
+var acquireScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	local token = redis.call("INCR", KEYS[2])
+	redis.call("SET", KEYS[1], ARGV[1] .. "/" .. token, "PX", ARGV[2])
+	return token
+end
+return 0
+`)
+
+// renewScript extends the lease only while it still holds our value.
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// LeaderElection campaigns for a lease in Redis so that a single replica
+// runs leader-only work. The lease is taken with SET NX PX and renewed every
+// third of its TTL; a replica that cannot renew steps down once its lease
+// may have expired, and another one takes over after the TTL. Every election
+// increments a fencing token that the leader should attach to its writes.
+type LeaderElection struct {
+	redis    *RedisClient
+	key      string
+	tokenKey string
+	id       string
+	ttl      time.Duration
+	mu       sync.RWMutex
+	leader   bool
+	token    int64
+	value    string
+	expires  time.Time
+	since    time.Time
+	holder   string
+}
+
+// MinLeaderTTL is the shortest lease accepted, anything below leaves no room
+// for a renewal round trip.
+const MinLeaderTTL = time.Second
+
+// NewLeaderElection campaigns for the lease name as candidate id. A ttl
+// below MinLeaderTTL is raised to it.
+func (r *RedisClient) NewLeaderElection(name, id string, ttl time.Duration) *LeaderElection {
+	if ttl < MinLeaderTTL {
+		log.Printf("Leader lease TTL %v is too short, using %v", ttl, MinLeaderTTL)
+		ttl = MinLeaderTTL
+	}
+
+	key := "leader:{" + name + "}"
+	return &LeaderElection{
+		redis:    r,
+		key:      key,
+		tokenKey: key + ":token",
+		id:       id,
+		ttl:      ttl,
+	}
+}
+
+func (e *LeaderElection) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.leader && time.Now().Before(e.expires)
+}
+
+// Token returns the fencing token of the current term, or 0 while not
+// leading.
+func (e *LeaderElection) Token() int64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.leader {
+		return 0
+	}
+	return e.token
+}
+
+// Run campaigns until ctx is cancelled and then releases the lease, so that
+// another replica can take over without waiting for the TTL.
+func (e *LeaderElection) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		e.campaign(ctx)
+
+		select {
+		case <-ctx.Done():
+			e.release()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *LeaderElection) campaign(ctx context.Context) {
+	opCtx, cancel := e.redis.withTimeout(ctx)
+	defer cancel()
+
+	// The lease is measured from before the request, so the local view
+	// never outlives the one in Redis.
+	start := time.Now()
+
+	e.mu.RLock()
+	leader, value := e.leader, e.value
+	e.mu.RUnlock()
+
+	if leader {
+		renewed, err := renewScript.Run(opCtx, e.redis.Client, []string{e.key}, value, e.ttl.Milliseconds()).Int64()
+		switch {
+		case err != nil:
+			log.Printf("Failed to renew leader lease %s: %v", e.key, err)
+			if !e.IsLeader() {
+				e.stepDown("lease expired before it could be renewed")
+			}
+		case renewed == 0:
+			e.stepDown("lease was taken over")
+		default:
+			e.mu.Lock()
+			e.expires = start.Add(e.ttl)
+			e.mu.Unlock()
+		}
+		return
+	}
+
+	token, err := acquireScript.Run(opCtx, e.redis.Client, []string{e.key, e.tokenKey}, e.id, e.ttl.Milliseconds()).Int64()
+	if err != nil {
+		log.Printf("Failed to campaign for leader lease %s: %v", e.key, err)
+		return
+	}
+
+	if token == 0 {
+		holder, err := e.redis.Client.Get(opCtx, e.key).Result()
+		if err != nil && err != redis.Nil {
+			return
+		}
+		e.mu.Lock()
+		e.holder = holder
+		e.mu.Unlock()
+		return
+	}
+
+	e.mu.Lock()
+	e.leader = true
+	e.token = token
+	e.value = e.id + "/" + strconv.FormatInt(token, 10)
+	e.holder = e.value
+	e.expires = start.Add(e.ttl)
+	e.since = time.Now()
+	e.mu.Unlock()
+
+	log.Printf("Elected leader of %s as %s with fencing token %d", e.key, e.id, token)
+}
+
+func (e *LeaderElection) stepDown(reason string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.leader {
+		return
+	}
+	log.Printf("Stepping down as leader of %s: %s", e.key, reason)
+
+	e.leader = false
+	e.value = ""
+	e.since = time.Now()
+}
+
+func (e *LeaderElection) release() {
+	e.mu.RLock()
+	leader, value := e.leader, e.value
+	e.mu.RUnlock()
+
+	if !leader {
+		return
+	}
+
+	ctx, cancel := e.redis.withTimeout(context.Background())
+	defer cancel()
+
+	if err := releaseScript.Run(ctx, e.redis.Client, []string{e.key}, value).Err(); err != nil {
+		log.Printf("Failed to release leader lease %s: %v", e.key, err)
+	}
+	e.stepDown("shutting down")
+}
+
+// Check reads the current holder of the lease. Being a follower is healthy,
+// the check only fails when the lease cannot be read.
+func (e *LeaderElection) Check(ctx context.Context) error {
+	holder, err := e.redis.Client.Get(ctx, e.key).Result()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to read leader lease %s: %v", e.key, err)
+	}
+
+	e.mu.Lock()
+	e.holder = holder
+	e.mu.Unlock()
+
+	return nil
+}
+
+func (e *LeaderElection) HealthDetails() map[string]interface{} {
+	leader := e.IsLeader()
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	details := map[string]interface{}{
+		"id":     e.id,
+		"leader": leader,
+		"holder": strings.SplitN(e.holder, "/", 2)[0],
+	}
+	if leader {
+		details["token"] = e.token
+		details["lease_expires"] = e.expires.UTC()
+	}
+	if !e.since.IsZero() {
+		details["since"] = e.since.UTC()
+	}
+	return details
+}
+
+func (e *LeaderElection) RegisterHealthCheck(registry *health.Registry, opts health.Options) error {
+	return registry.Register(health.Check{Name: "leader", Checker: e, Options: opts})
+}
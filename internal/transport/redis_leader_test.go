@@ -0,0 +1,90 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLeaderElectionFencing(t *testing.T) {
+	r, mr := newTestRedis(t)
+	ctx := context.Background()
+
+	a := r.NewLeaderElection("ingest", "pod-a", 3*time.Second)
+	b := r.NewLeaderElection("ingest", "pod-b", 3*time.Second)
+
+	a.campaign(ctx)
+	b.campaign(ctx)
+
+	if !a.IsLeader() || a.Token() != 1 {
+		t.Fatalf("pod-a leader = %v token %d, want leader with token 1", a.IsLeader(), a.Token())
+	}
+	if b.IsLeader() || b.Token() != 0 {
+		t.Fatalf("pod-b leader = %v token %d, want follower with token 0", b.IsLeader(), b.Token())
+	}
+	if holder := b.HealthDetails()["holder"]; holder != "pod-a" {
+		t.Errorf("pod-b sees holder %v, want pod-a", holder)
+	}
+
+	// Renewing keeps the lease and the token.
+	mr.FastForward(2 * time.Second)
+	a.campaign(ctx)
+	mr.FastForward(2 * time.Second)
+	b.campaign(ctx)
+	if !a.IsLeader() || a.Token() != 1 || b.IsLeader() {
+		t.Fatalf("after renewal pod-a leader = %v token %d, pod-b leader = %v", a.IsLeader(), a.Token(), b.IsLeader())
+	}
+
+	// pod-a stalls past its lease, pod-b takes over with a higher token.
+	mr.FastForward(4 * time.Second)
+	b.campaign(ctx)
+	if !b.IsLeader() || b.Token() != 2 {
+		t.Fatalf("pod-b leader = %v token %d, want leader with token 2", b.IsLeader(), b.Token())
+	}
+
+	// The deposed leader must not renew the new leader's lease.
+	a.campaign(ctx)
+	if a.IsLeader() || a.Token() != 0 {
+		t.Errorf("deposed pod-a leader = %v token %d, want follower with token 0", a.IsLeader(), a.Token())
+	}
+	if ttl := mr.TTL("leader:{ingest}"); ttl <= 0 {
+		t.Errorf("lease TTL = %v, want the new leader's lease to stay", ttl)
+	}
+}
+
+func TestLeaderElectionRelease(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	a := r.NewLeaderElection("ingest", "pod-a", 3*time.Second)
+	b := r.NewLeaderElection("ingest", "pod-b", 3*time.Second)
+
+	a.campaign(ctx)
+	a.release()
+	if a.IsLeader() {
+		t.Fatal("pod-a still leader after release")
+	}
+
+	// The lease is free right away, no need to wait for the TTL.
+	b.campaign(ctx)
+	if !b.IsLeader() || b.Token() != 2 {
+		t.Errorf("pod-b leader = %v token %d, want leader with token 2", b.IsLeader(), b.Token())
+	}
+
+	// Releasing as a follower leaves the leader's lease alone.
+	a.release()
+	b.campaign(ctx)
+	if !b.IsLeader() {
+		t.Error("pod-b lost the lease to a follower's release")
+	}
+}
+
+func TestLeaderElectionMinimumTTL(t *testing.T) {
+	r, _ := newTestRedis(t)
+
+	for _, ttl := range []time.Duration{0, time.Millisecond, MinLeaderTTL - 1} {
+		if e := r.NewLeaderElection("ingest", "pod-a", ttl); e.ttl != MinLeaderTTL {
+			t.Errorf("ttl %v became %v, want %v", ttl, e.ttl, MinLeaderTTL)
+		}
+	}
+}
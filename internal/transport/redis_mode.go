@@ -0,0 +1,271 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/config"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	RedisStandalone = "standalone"
+	RedisSentinel   = "sentinel"
+	RedisCluster    = "cluster"
+)
+
+// redisTopology tracks what the health check learned about a Sentinel or
+// Cluster deployment.
+type redisTopology struct {
+	mu           sync.RWMutex
+	master       string
+	failovers    int
+	lastFailover time.Time
+	shards       int
+}
+
+// newUniversalClient builds the client for the configured REDIS_MODE. The
+// constructor is picked explicitly rather than through redis.NewUniversalClient,
+// which would treat a cluster seeded with a single address as standalone.
+func newUniversalClient(redisURL string, cnf *config.Config) (string, redis.UniversalClient, error) {
+	mode := strings.ToLower(cnf.RedisMode)
+	if mode == "" {
+		mode = RedisStandalone
+	}
+
+	opts := &redis.UniversalOptions{
+		Addrs:            cnf.RedisAddrs,
+		MasterName:       cnf.RedisMasterName,
+		Password:         cnf.RedisPassword,
+		SentinelPassword: cnf.RedisSentinelPassword,
+		DB:               cnf.RedisDB,
+	}
+
+	switch mode {
+	case RedisStandalone:
+		if len(opts.Addrs) == 0 {
+			parsed, err := redis.ParseURL(redisURL)
+			if err != nil {
+				return "", nil, fmt.Errorf("failed to parse Redis URL: %v", err)
+			}
+			if opts.Password == "" {
+				opts.Password = parsed.Password
+			}
+			opts.Addrs = []string{parsed.Addr}
+			opts.Username = parsed.Username
+			opts.DB = parsed.DB
+			opts.TLSConfig = parsed.TLSConfig
+		}
+		return mode, redis.NewClient(opts.Simple()), nil
+	case RedisSentinel:
+		if opts.MasterName == "" || len(opts.Addrs) == 0 {
+			return "", nil, fmt.Errorf("redis sentinel mode requires REDIS_MASTER_NAME and REDIS_ADDRS")
+		}
+		return mode, redis.NewFailoverClient(opts.Failover()), nil
+	case RedisCluster:
+		if len(opts.Addrs) == 0 {
+			return "", nil, fmt.Errorf("redis cluster mode requires REDIS_ADDRS")
+		}
+		if opts.DB != 0 {
+			return "", nil, fmt.Errorf("redis cluster mode only supports database 0")
+		}
+		return mode, redis.NewClusterClient(opts.Cluster()), nil
+	default:
+		return "", nil, fmt.Errorf("unknown REDIS_MODE %q", cnf.RedisMode)
+	}
+}
+
+// newSentinelClients connects to every sentinel once, the health check reuses
+// them to follow the master.
+func newSentinelClients(cnf *config.Config) []*redis.SentinelClient {
+	sentinels := make([]*redis.SentinelClient, 0, len(cnf.RedisAddrs))
+	for _, addr := range cnf.RedisAddrs {
+		sentinels = append(sentinels, redis.NewSentinelClient(&redis.Options{
+			Addr:     addr,
+			Password: cnf.RedisSentinelPassword,
+		}))
+	}
+	return sentinels
+}
+
+// Close closes the client and the sentinel connections.
+func (r *RedisClient) Close() error {
+	for _, sentinel := range r.sentinels {
+		sentinel.Close()
+	}
+	return r.Client.Close()
+}
+
+// Mode reports whether the client runs standalone, against Sentinel or
+// against a Cluster.
+func (r *RedisClient) Mode() string {
+	return r.mode
+}
+
+// Check pings the node serving writes. In Sentinel mode it also follows the
+// master the sentinels advertise and fails while the connected node is still
+// a replica, which is the window of a failover. In Cluster mode every shard
+// must answer and the cluster state must be ok.
+func (r *RedisClient) Check(ctx context.Context) error {
+	if err := r.state.err(); err != nil {
+		return err
+	}
+
+	switch r.mode {
+	case RedisSentinel:
+		return r.checkSentinel(ctx)
+	case RedisCluster:
+		return r.checkCluster(ctx)
+	default:
+		return r.Client.Ping(ctx).Err()
+	}
+}
+
+func (r *RedisClient) checkSentinel(ctx context.Context) error {
+	if master, err := r.sentinelMaster(ctx); err != nil {
+		log.Printf("Failed to resolve Redis master %s: %v", r.config.RedisMasterName, err)
+	} else {
+		r.topology.mu.Lock()
+		if r.topology.master != "" && r.topology.master != master {
+			log.Printf("Redis master %s failed over from %s to %s", r.config.RedisMasterName, r.topology.master, master)
+			r.topology.failovers++
+			r.topology.lastFailover = time.Now()
+		}
+		r.topology.master = master
+		r.topology.mu.Unlock()
+	}
+
+	role, err := r.Client.Do(ctx, "ROLE").Slice()
+	if err != nil {
+		return err
+	}
+	if len(role) == 0 || role[0] != "master" {
+		return fmt.Errorf("redis node for %s is not a master, failover in progress", r.config.RedisMasterName)
+	}
+	return nil
+}
+
+// sentinelMaster asks the sentinels in turn for the current master address.
+func (r *RedisClient) sentinelMaster(ctx context.Context) (string, error) {
+	var err error
+	for _, sentinel := range r.sentinels {
+		var master []string
+		master, err = sentinel.GetMasterAddrByName(ctx, r.config.RedisMasterName).Result()
+		if err == nil && len(master) == 2 {
+			return net.JoinHostPort(master[0], master[1]), nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("no sentinel knows master %s", r.config.RedisMasterName)
+	}
+	return "", err
+}
+
+func (r *RedisClient) checkCluster(ctx context.Context) error {
+	cluster := r.Client.(*redis.ClusterClient)
+
+	info, err := cluster.ClusterInfo(ctx).Result()
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(info, "cluster_state:ok") {
+		return fmt.Errorf("redis cluster state is not ok")
+	}
+
+	var mu sync.Mutex
+	shards := 0
+	err = cluster.ForEachShard(ctx, func(ctx context.Context, shard *redis.Client) error {
+		if err := shard.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("redis shard %s: %v", shard.Options().Addr, err)
+		}
+		mu.Lock()
+		shards++
+		mu.Unlock()
+		return nil
+	})
+
+	r.topology.mu.Lock()
+	r.topology.shards = shards
+	r.topology.mu.Unlock()
+
+	return err
+}
+
+func (r *RedisClient) HealthDetails() map[string]interface{} {
+	r.topology.mu.RLock()
+	defer r.topology.mu.RUnlock()
+
+	details := map[string]interface{}{
+		"mode": r.mode,
+	}
+	r.state.details(details)
+	switch r.mode {
+	case RedisSentinel:
+		details["master_name"] = r.config.RedisMasterName
+		details["master"] = r.topology.master
+		details["failovers"] = r.topology.failovers
+		if !r.topology.lastFailover.IsZero() {
+			details["last_failover"] = r.topology.lastFailover.UTC()
+		}
+	case RedisCluster:
+		details["shards"] = r.topology.shards
+	}
+	return details
+}
+
+// scanNodes returns the nodes a keyspace scan has to walk: every master of a
+// cluster, or the client itself.
+func (r *RedisClient) scanNodes(ctx context.Context) ([]redis.Cmdable, error) {
+	cluster, ok := r.Client.(*redis.ClusterClient)
+	if !ok {
+		return []redis.Cmdable{r.Client}, nil
+	}
+
+	var mu sync.Mutex
+	var nodes []redis.Cmdable
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		mu.Lock()
+		nodes = append(nodes, master)
+		mu.Unlock()
+		return nil
+	})
+	return nodes, err
+}
+
+// mget fetches keys in one round trip. Keys of a cluster usually live in
+// different hash slots, where MGET fails with CROSSSLOT, so they are
+// pipelined as single GETs instead.
+func (r *RedisClient) mget(ctx context.Context, keys ...string) ([]interface{}, error) {
+	if r.mode != RedisCluster {
+		return r.Client.MGet(ctx, keys...).Result()
+	}
+
+	pipe := r.Client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(ctx, key)
+	}
+	pipe.Exec(ctx)
+
+	vals := make([]interface{}, len(keys))
+	for i, cmd := range cmds {
+		val, err := cmd.Result()
+		switch {
+		case err == nil:
+			vals[i] = val
+		case err == redis.Nil || isWrongType(err):
+		default:
+			return nil, err
+		}
+	}
+	return vals, nil
+}
+
+func isWrongType(err error) bool {
+	return strings.HasPrefix(err.Error(), "WRONGTYPE")
+}
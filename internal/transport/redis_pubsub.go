@@ -0,0 +1,165 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/health"
+	"github.com/go-redis/redis/v8"
+)
+
+// Publish sends data to a pub/sub channel and returns the number of clients
+// that received it.
+func (r *RedisClient) Publish(ctx context.Context, channel string, data []byte) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	return r.Client.Publish(ctx, channel, data).Result()
+}
+
+// RedisSubscription receives the messages of every channel matching its
+// patterns and dispatches them to the registered handlers. When the
+// connection drops, go-redis redials and subscribes to the patterns again on
+// the next receive, Run only paces the attempts with the reconnect policy.
+type RedisSubscription struct {
+	redis    *RedisClient
+	pubsub   *redis.PubSub
+	patterns []string
+	mu       sync.RWMutex
+	handlers []MessageHandler
+	state    connectionState
+}
+
+// Subscribe subscribes to patterns with PSUBSCRIBE. Messages are delivered
+// once Run is called.
+func (r *RedisClient) Subscribe(ctx context.Context, patterns ...string) (*RedisSubscription, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("redis subscription requires at least one pattern")
+	}
+
+	pubsub := r.Client.PSubscribe(ctx)
+	if err := pubsub.PSubscribe(ctx, patterns...); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to %v: %v", patterns, err)
+	}
+
+	return &RedisSubscription{redis: r, pubsub: pubsub, patterns: patterns}, nil
+}
+
+func (s *RedisSubscription) Patterns() []string {
+	return s.patterns
+}
+
+// Handle registers a handler for every message received. Handlers run on the
+// receive loop and should not block.
+func (s *RedisSubscription) Handle(handler MessageHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers = append(s.handlers, handler)
+}
+
+// Run receives messages until ctx is cancelled and closes the subscription
+// before returning. Once the reconnect policy gives up the subscription is
+// reported as failed, but Run keeps resubscribing every MaxInterval.
+func (s *RedisSubscription) Run(ctx context.Context) error {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// Receive does not watch ctx, closing the subscription unblocks it.
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		s.pubsub.Close()
+	}()
+
+	log.Printf("Redis subscription started for patterns: %v", s.patterns)
+
+	for {
+		received, err := s.pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil || err == redis.ErrClosed {
+				log.Println("Redis subscription stopped")
+				return nil
+			}
+
+			attempt := s.state.failed(err)
+			wait := s.redis.reconnect.Delay(attempt)
+			switch {
+			case s.redis.reconnect.Exhausted(attempt):
+				if s.state.err() == nil {
+					log.Printf("Giving up on redis subscription after %d attempts, probing every %v: %v", attempt, s.redis.reconnect.MaxInterval, err)
+					s.state.giveUp(err)
+				}
+				wait = s.redis.reconnect.ProbeDelay()
+			default:
+				log.Printf("Redis subscription failed, attempt %d, resubscribing in %v: %v", attempt, wait.Round(time.Millisecond), err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		switch m := received.(type) {
+		case *redis.Subscription:
+			if !s.state.isConnected() && m.Kind == "psubscribe" {
+				log.Printf("Subscribed to Redis pattern %s", m.Channel)
+				s.state.connected()
+			}
+		case *redis.Message:
+			s.dispatch(ctx, m)
+		}
+	}
+}
+
+func (s *RedisSubscription) dispatch(ctx context.Context, m *redis.Message) {
+	msg := &Message{
+		Source:    "redis-pubsub",
+		Topic:     m.Channel,
+		Value:     []byte(m.Payload),
+		Headers:   map[string]string{"pattern": m.Pattern},
+		Timestamp: time.Now(),
+	}
+
+	s.mu.RLock()
+	handlers := s.handlers
+	s.mu.RUnlock()
+
+	for _, h := range handlers {
+		if err := h.HandleMessage(ctx, msg); err != nil {
+			log.Printf("Redis pub/sub handler failed for %s: %v", m.Channel, err)
+		}
+	}
+}
+
+// Check pings over the subscription connection, the pong is consumed by Run.
+func (s *RedisSubscription) Check(ctx context.Context) error {
+	if err := s.state.err(); err != nil {
+		return err
+	}
+	if !s.state.isConnected() {
+		return fmt.Errorf("redis subscription is not subscribed")
+	}
+	return s.pubsub.Ping(ctx)
+}
+
+func (s *RedisSubscription) HealthDetails() map[string]interface{} {
+	details := map[string]interface{}{
+		"patterns": s.patterns,
+	}
+	s.state.details(details)
+	return details
+}
+
+func (s *RedisSubscription) RegisterHealthCheck(registry *health.Registry, opts health.Options) error {
+	return registry.Register(health.Check{Name: "redis-pubsub", Checker: s, Options: opts})
+}
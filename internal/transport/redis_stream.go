@@ -0,0 +1,517 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/health"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// HeaderStreamID carries the entry ID of messages read from a Redis stream.
+	HeaderStreamID = "x-stream-id"
+
+	streamFieldKey     = "key"
+	streamFieldValue   = "value"
+	streamHeaderPrefix = "h:"
+
+	DefaultStreamBlock     = 2 * time.Second
+	DefaultStreamCount     = 100
+	DefaultStreamClaimIdle = 30 * time.Second
+)
+
+// StreamPublish appends a message to stream with XADD and returns its entry
+// ID. A positive maxLen trims the stream to roughly that many entries, the
+// approximate form lets Redis trim whole macro nodes cheaply.
+func (r *RedisClient) StreamPublish(ctx context.Context, stream string, key, value []byte, headers map[string]string, maxLen int64) (string, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	values := make([]interface{}, 0, 4+2*len(headers))
+	values = append(values, streamFieldKey, key, streamFieldValue, value)
+	for k, v := range headers {
+		values = append(values, streamHeaderPrefix+k, v)
+	}
+
+	args := &redis.XAddArgs{Stream: stream, Values: values}
+	if maxLen > 0 {
+		args.MaxLen = maxLen
+		args.Approx = true
+	}
+
+	return r.Client.XAdd(ctx, args).Result()
+}
+
+// RedisStreamConsumer reads streams as a member of a consumer group and
+// dispatches every entry to the handlers registered for its stream. Entries
+// are acknowledged once all handlers succeeded. Failed entries stay pending
+// and are claimed again with XAUTOCLAIM after they have been idle for the
+// claim interval, which also recovers entries of consumers that died. An
+// entry that keeps failing for reasons other than a transient one is moved
+// to "<stream>.dlq" once it was delivered maxDeliveries times.
+type RedisStreamConsumer struct {
+	redis         *RedisClient
+	group         string
+	name          string
+	streams       []string
+	block         time.Duration
+	count         int64
+	claimIdle     time.Duration
+	maxDeliveries int64
+	mu            sync.RWMutex
+	handlers      map[string][]MessageHandler
+	statsMu       sync.RWMutex
+	pending       map[string]int64
+	claimed       int64
+}
+
+// NewStreamConsumer joins group as consumer name, creating the group and
+// the streams when they do not exist yet. A new group starts with entries
+// added after its creation.
+func (r *RedisClient) NewStreamConsumer(ctx context.Context, group, name string, streams []string) (*RedisStreamConsumer, error) {
+	for _, stream := range streams {
+		opCtx, cancel := r.withTimeout(ctx)
+		err := r.Client.XGroupCreateMkStream(opCtx, stream, group, "$").Err()
+		cancel()
+
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return nil, fmt.Errorf("failed to create consumer group %s on %s: %v", group, stream, err)
+		}
+	}
+
+	return &RedisStreamConsumer{
+		redis:     r,
+		group:     group,
+		name:      name,
+		streams:   streams,
+		block:     DefaultStreamBlock,
+		count:     DefaultStreamCount,
+		claimIdle: DefaultStreamClaimIdle,
+		handlers:  make(map[string][]MessageHandler),
+		pending:   make(map[string]int64),
+	}, nil
+}
+
+// SetMaxDeliveries sets after how many deliveries a failing entry is
+// dead-lettered, 0 keeps it pending forever.
+func (c *RedisStreamConsumer) SetMaxDeliveries(max int64) {
+	c.maxDeliveries = max
+}
+
+// SetBlock sets how long a read waits for new entries.
+func (c *RedisStreamConsumer) SetBlock(block time.Duration) {
+	if block > 0 {
+		c.block = block
+	}
+}
+
+// SetClaimIdle sets how long an entry must stay pending before it is claimed
+// again. It is also the interval of the claim loop.
+func (c *RedisStreamConsumer) SetClaimIdle(idle time.Duration) {
+	if idle > 0 {
+		c.claimIdle = idle
+	}
+}
+
+func (c *RedisStreamConsumer) Handle(stream string, handler MessageHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handlers[stream] = append(c.handlers[stream], handler)
+}
+
+// Run reads every stream in its own loop, so that streams living in
+// different cluster slots never share a command, and blocks until ctx is
+// cancelled.
+func (c *RedisStreamConsumer) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	for _, stream := range c.streams {
+		wg.Add(1)
+		go func(stream string) {
+			defer wg.Done()
+			c.read(ctx, stream)
+		}(stream)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.claimLoop(ctx)
+	}()
+
+	log.Printf("Redis stream consumer %s/%s started for streams: %v", c.group, c.name, c.streams)
+
+	wg.Wait()
+	log.Println("Redis stream consumer stopped")
+
+	return nil
+}
+
+func (c *RedisStreamConsumer) read(ctx context.Context, stream string) {
+	failures := 0
+
+	for ctx.Err() == nil {
+		// The read blocks on the server, give it the operation timeout on top.
+		readCtx, cancel := context.WithTimeout(ctx, c.block+c.redis.opTimeout)
+		streams, err := c.redis.Client.XReadGroup(readCtx, &redis.XReadGroupArgs{
+			Group:    c.group,
+			Consumer: c.name,
+			Streams:  []string{stream, ">"},
+			Count:    c.count,
+			Block:    c.block,
+		}).Result()
+		cancel()
+
+		if err == redis.Nil {
+			failures = 0
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			failures++
+			wait := c.redis.reconnect.Delay(failures)
+			log.Printf("Failed to read Redis stream %s, retrying in %v: %v", stream, wait.Round(time.Millisecond), err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		failures = 0
+		for _, s := range streams {
+			for _, m := range s.Messages {
+				c.dispatch(ctx, s.Stream, m, 1)
+			}
+		}
+	}
+}
+
+// claimLoop takes over entries that stayed pending longer than claimIdle,
+// whether they failed here or their consumer is gone.
+func (c *RedisStreamConsumer) claimLoop(ctx context.Context) {
+	ticker := time.NewTicker(c.claimIdle)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, stream := range c.streams {
+			if err := c.claim(ctx, stream); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to claim pending entries of %s: %v", stream, err)
+			}
+		}
+	}
+}
+
+func (c *RedisStreamConsumer) claim(ctx context.Context, stream string) error {
+	start := "0-0"
+
+	for {
+		opCtx, cancel := c.redis.withTimeout(ctx)
+		messages, next, deleted, err := c.autoClaim(opCtx, stream, start)
+		cancel()
+
+		if err != nil {
+			return err
+		}
+
+		if deleted > 0 {
+			end := next
+			if next == "0-0" || next == "" {
+				end = "+"
+			}
+			if err := c.ackDeleted(ctx, stream, start, end, messages); err != nil {
+				log.Printf("Failed to ack deleted pending entries of %s: %v", stream, err)
+			}
+		}
+
+		if len(messages) > 0 {
+			log.Printf("Claimed %d pending entries of %s", len(messages), stream)
+
+			c.statsMu.Lock()
+			c.claimed += int64(len(messages))
+			c.statsMu.Unlock()
+
+			deliveries, err := c.deliveries(ctx, stream, messages)
+			if err != nil {
+				return err
+			}
+
+			for i, m := range messages {
+				c.dispatch(ctx, stream, m, deliveries[i])
+			}
+		}
+
+		if next == "0-0" || next == "" {
+			return nil
+		}
+		start = next
+	}
+}
+
+// deliveries reads how often each claimed entry has been delivered,
+// including the claim itself.
+func (c *RedisStreamConsumer) deliveries(ctx context.Context, stream string, messages []redis.XMessage) ([]int64, error) {
+	opCtx, cancel := c.redis.withTimeout(ctx)
+	defer cancel()
+
+	pipe := c.redis.Client.Pipeline()
+	cmds := make([]*redis.XPendingExtCmd, len(messages))
+	for i, m := range messages {
+		cmds[i] = pipe.XPendingExt(opCtx, &redis.XPendingExtArgs{Stream: stream, Group: c.group, Start: m.ID, End: m.ID, Count: 1})
+	}
+	if _, err := pipe.Exec(opCtx); err != nil {
+		return nil, fmt.Errorf("failed to read delivery counts: %v", err)
+	}
+
+	counts := make([]int64, len(messages))
+	for i, cmd := range cmds {
+		if pending := cmd.Val(); len(pending) == 1 {
+			counts[i] = pending[0].RetryCount
+		}
+	}
+	return counts, nil
+}
+
+// ackDeleted acknowledges the entries XAUTOCLAIM reported as nil on Redis
+// 6.2: they were deleted from the stream while pending, so no handler can
+// ever process them, yet Redis 6.2 leaves them in the PEL. The reply does not
+// carry their IDs, so the PEL of this consumer between start and end is
+// checked against the stream.
+func (c *RedisStreamConsumer) ackDeleted(ctx context.Context, stream, start, end string, claimed []redis.XMessage) error {
+	present := make(map[string]bool, len(claimed))
+	for _, m := range claimed {
+		present[m.ID] = true
+	}
+
+	var gone []string
+	for {
+		opCtx, cancel := c.redis.withTimeout(ctx)
+		pending, err := c.redis.Client.XPendingExt(opCtx, &redis.XPendingExtArgs{
+			Stream: stream, Group: c.group, Consumer: c.name, Start: start, End: end, Count: c.count,
+		}).Result()
+		cancel()
+
+		if err != nil {
+			return err
+		}
+
+		for _, p := range pending {
+			if present[p.ID] {
+				continue
+			}
+
+			opCtx, cancel := c.redis.withTimeout(ctx)
+			entries, err := c.redis.Client.XRange(opCtx, stream, p.ID, p.ID).Result()
+			cancel()
+
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				gone = append(gone, p.ID)
+			}
+		}
+
+		if int64(len(pending)) < c.count {
+			break
+		}
+		start = "(" + pending[len(pending)-1].ID
+	}
+
+	if len(gone) == 0 {
+		return nil
+	}
+
+	opCtx, cancel := c.redis.withTimeout(ctx)
+	defer cancel()
+
+	if err := c.redis.Client.XAck(opCtx, stream, c.group, gone...).Err(); err != nil {
+		return err
+	}
+	log.Printf("Acknowledged %d entries of %s deleted while pending", len(gone), stream)
+	return nil
+}
+
+// autoClaim issues XAUTOCLAIM and parses the reply itself: go-redis v8
+// expects two elements, but Redis 7 appends the IDs of deleted entries,
+// which it also removes from the PEL. Redis 6.2 returns entries deleted
+// while pending as nil and keeps them pending, their number is returned so
+// that they can be acknowledged.
+func (c *RedisStreamConsumer) autoClaim(ctx context.Context, stream, start string) ([]redis.XMessage, string, int, error) {
+	reply, err := c.redis.Client.Do(ctx, "XAUTOCLAIM", stream, c.group, c.name,
+		c.claimIdle.Milliseconds(), start, "COUNT", c.count).Slice()
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return parseAutoClaim(reply)
+}
+
+func parseAutoClaim(reply []interface{}) ([]redis.XMessage, string, int, error) {
+	if len(reply) < 2 {
+		return nil, "", 0, fmt.Errorf("unexpected XAUTOCLAIM reply of %d elements", len(reply))
+	}
+
+	next, _ := reply[0].(string)
+	entries, _ := reply[1].([]interface{})
+
+	deleted := 0
+	messages := make([]redis.XMessage, 0, len(entries))
+	for _, e := range entries {
+		entry, ok := e.([]interface{})
+		if !ok || len(entry) != 2 {
+			deleted++
+			continue
+		}
+
+		id, _ := entry[0].(string)
+		fields, _ := entry[1].([]interface{})
+
+		values := make(map[string]interface{}, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			if field, ok := fields[i].(string); ok {
+				values[field] = fields[i+1]
+			}
+		}
+
+		messages = append(messages, redis.XMessage{ID: id, Values: values})
+	}
+
+	return messages, next, deleted, nil
+}
+
+// dispatch runs the handlers of an entry delivered deliveries times and
+// acknowledges it once they succeeded or it was dead-lettered.
+func (c *RedisStreamConsumer) dispatch(ctx context.Context, stream string, m redis.XMessage, deliveries int64) {
+	msg := newStreamMessage(stream, m)
+
+	c.mu.RLock()
+	handlers := c.handlers[stream]
+	c.mu.RUnlock()
+
+	if err := runHandlers(ctx, handlers, msg); err != nil {
+		if c.maxDeliveries <= 0 || deliveries < c.maxDeliveries || IsTransient(err) || ctx.Err() != nil {
+			log.Printf("Handler failed for %s@%s, leaving it pending: %v", stream, m.ID, err)
+			return
+		}
+
+		if dlqErr := c.deadLetter(ctx, stream, msg, err, deliveries); dlqErr != nil {
+			log.Printf("Failed to dead-letter %s@%s, leaving it pending: %v", stream, m.ID, dlqErr)
+			return
+		}
+		log.Printf("Handler failed for %s@%s %d times, moved it to %s: %v", stream, m.ID, deliveries, DeadLetterTopic(stream), err)
+	}
+
+	ackCtx, cancel := c.redis.withTimeout(ctx)
+	defer cancel()
+
+	if err := c.redis.Client.XAck(ackCtx, stream, c.group, m.ID).Err(); err != nil {
+		log.Printf("Failed to ack %s@%s: %v", stream, m.ID, err)
+	}
+}
+
+// deadLetter appends the entry to "<stream>.dlq" with the dead-letter
+// headers of the Kafka pipeline, its original ID stays in x-stream-id.
+func (c *RedisStreamConsumer) deadLetter(ctx context.Context, stream string, msg *Message, cause error, deliveries int64) error {
+	headers := make(map[string]string, len(msg.Headers)+3)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+
+	headers[HeaderDLQError] = cause.Error()
+	headers[HeaderDLQAttempts] = strconv.FormatInt(deliveries, 10)
+	headers[HeaderDLQSourceTopic] = stream
+
+	_, err := c.redis.StreamPublish(ctx, DeadLetterTopic(stream), msg.Key, msg.Value, headers, 0)
+	return err
+}
+
+func newStreamMessage(stream string, m redis.XMessage) *Message {
+	msg := &Message{
+		Source:    "redis-stream",
+		Topic:     stream,
+		Headers:   map[string]string{HeaderStreamID: m.ID},
+		Timestamp: streamIDTime(m.ID),
+	}
+
+	for field, value := range m.Values {
+		s, _ := value.(string)
+		switch {
+		case field == streamFieldKey:
+			msg.Key = []byte(s)
+		case field == streamFieldValue:
+			msg.Value = []byte(s)
+		case strings.HasPrefix(field, streamHeaderPrefix):
+			msg.Headers[strings.TrimPrefix(field, streamHeaderPrefix)] = s
+		}
+	}
+
+	return msg
+}
+
+// streamIDTime extracts the millisecond timestamp of a "<ms>-<seq>" entry ID.
+func streamIDTime(id string) time.Time {
+	ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+	return time.UnixMilli(ms)
+}
+
+// Check reads the number of pending entries of every stream, which fails
+// when the group has disappeared, e.g. after the stream was deleted.
+func (c *RedisStreamConsumer) Check(ctx context.Context) error {
+	pending := make(map[string]int64, len(c.streams))
+
+	for _, stream := range c.streams {
+		p, err := c.redis.Client.XPending(ctx, stream, c.group).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read pending entries of %s: %v", stream, err)
+		}
+		pending[stream] = p.Count
+	}
+
+	c.statsMu.Lock()
+	c.pending = pending
+	c.statsMu.Unlock()
+
+	return nil
+}
+
+func (c *RedisStreamConsumer) HealthDetails() map[string]interface{} {
+	c.statsMu.RLock()
+	defer c.statsMu.RUnlock()
+
+	pending := make(map[string]int64, len(c.pending))
+	for stream, count := range c.pending {
+		pending[stream] = count
+	}
+
+	return map[string]interface{}{
+		"group":    c.group,
+		"consumer": c.name,
+		"pending":  pending,
+		"claimed":  c.claimed,
+	}
+}
+
+func (c *RedisStreamConsumer) RegisterHealthCheck(registry *health.Registry, opts health.Options) error {
+	return registry.Register(health.Check{Name: "redis-streams", Checker: c, Options: opts})
+}
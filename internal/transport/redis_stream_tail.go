@@ -0,0 +1,151 @@
+package transport
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisStreamTail follows streams with plain XREAD, starting after the last
+// entry present when it starts. Unlike the group consumer every replica
+// reads every entry; nothing is acknowledged and a failing handler is
+// neither retried nor redelivered.
+type RedisStreamTail struct {
+	redis    *RedisClient
+	streams  []string
+	block    time.Duration
+	count    int64
+	mu       sync.RWMutex
+	handlers map[string][]MessageHandler
+}
+
+func (r *RedisClient) NewStreamTail(streams []string) *RedisStreamTail {
+	return &RedisStreamTail{
+		redis:    r,
+		streams:  streams,
+		block:    DefaultStreamBlock,
+		count:    DefaultStreamCount,
+		handlers: make(map[string][]MessageHandler),
+	}
+}
+
+// SetBlock sets how long a read waits for new entries.
+func (t *RedisStreamTail) SetBlock(block time.Duration) {
+	if block > 0 {
+		t.block = block
+	}
+}
+
+func (t *RedisStreamTail) Handle(stream string, handler MessageHandler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.handlers[stream] = append(t.handlers[stream], handler)
+}
+
+// Run follows every stream in its own loop and blocks until ctx is
+// cancelled.
+func (t *RedisStreamTail) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	for _, stream := range t.streams {
+		wg.Add(1)
+		go func(stream string) {
+			defer wg.Done()
+			t.follow(ctx, stream)
+		}(stream)
+	}
+
+	log.Printf("Redis stream tail started for streams: %v", t.streams)
+
+	wg.Wait()
+	log.Println("Redis stream tail stopped")
+
+	return nil
+}
+
+// follow reads from the last entry it saw rather than from "$", which would
+// skip whatever was added between two reads.
+func (t *RedisStreamTail) follow(ctx context.Context, stream string) {
+	last := ""
+	failures := 0
+
+	for ctx.Err() == nil {
+		var err error
+		if last == "" {
+			last, err = t.lastID(ctx, stream)
+		}
+
+		var streams []redis.XStream
+		if err == nil {
+			// The read blocks on the server, give it the operation timeout on top.
+			readCtx, cancel := context.WithTimeout(ctx, t.block+t.redis.opTimeout)
+			streams, err = t.redis.Client.XRead(readCtx, &redis.XReadArgs{
+				Streams: []string{stream, last},
+				Count:   t.count,
+				Block:   t.block,
+			}).Result()
+			cancel()
+		}
+
+		if err == redis.Nil {
+			failures = 0
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			failures++
+			wait := t.redis.reconnect.Delay(failures)
+			log.Printf("Failed to tail Redis stream %s, retrying in %v: %v", stream, wait.Round(time.Millisecond), err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		failures = 0
+		for _, s := range streams {
+			for _, m := range s.Messages {
+				t.dispatch(ctx, s.Stream, m)
+				last = m.ID
+			}
+		}
+	}
+}
+
+// lastID returns the ID of the newest entry of stream, or "0-0" when it is
+// empty or does not exist yet.
+func (t *RedisStreamTail) lastID(ctx context.Context, stream string) (string, error) {
+	opCtx, cancel := t.redis.withTimeout(ctx)
+	defer cancel()
+
+	entries, err := t.redis.Client.XRevRangeN(opCtx, stream, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "0-0", nil
+	}
+	return entries[0].ID, nil
+}
+
+func (t *RedisStreamTail) dispatch(ctx context.Context, stream string, m redis.XMessage) {
+	msg := newStreamMessage(stream, m)
+
+	t.mu.RLock()
+	handlers := t.handlers[stream]
+	t.mu.RUnlock()
+
+	if err := runHandlers(ctx, handlers, msg); err != nil {
+		log.Printf("Tail handler failed for %s@%s: %v", stream, m.ID, err)
+	}
+}
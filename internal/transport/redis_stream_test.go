@@ -0,0 +1,229 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestParseAutoClaim(t *testing.T) {
+	entry := []interface{}{"1-0", []interface{}{"key", "k", "value", "v"}}
+	want := []redis.XMessage{{ID: "1-0", Values: map[string]interface{}{"key": "k", "value": "v"}}}
+
+	tests := []struct {
+		name     string
+		reply    []interface{}
+		next     string
+		messages []redis.XMessage
+		deleted  int
+		wantErr  bool
+	}{
+		{
+			name:     "redis 6.2",
+			reply:    []interface{}{"2-0", []interface{}{entry}},
+			next:     "2-0",
+			messages: want,
+		},
+		{
+			name:     "redis 6.2 counts entries deleted while pending",
+			reply:    []interface{}{"0-0", []interface{}{nil, entry}},
+			next:     "0-0",
+			messages: want,
+			deleted:  1,
+		},
+		{
+			name:     "redis 7 with deleted ids",
+			reply:    []interface{}{"0-0", []interface{}{entry}, []interface{}{"0-5"}},
+			next:     "0-0",
+			messages: want,
+		},
+		{
+			name:     "nothing to claim",
+			reply:    []interface{}{"0-0", []interface{}{}, []interface{}{}},
+			next:     "0-0",
+			messages: []redis.XMessage{},
+		},
+		{
+			name:    "short reply",
+			reply:   []interface{}{"0-0"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, next, deleted, err := parseAutoClaim(tt.reply)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("parseAutoClaim() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAutoClaim() failed: %v", err)
+			}
+			if next != tt.next {
+				t.Errorf("next = %q, want %q", next, tt.next)
+			}
+			if !reflect.DeepEqual(messages, tt.messages) {
+				t.Errorf("messages = %#v, want %#v", messages, tt.messages)
+			}
+			if deleted != tt.deleted {
+				t.Errorf("deleted = %d, want %d", deleted, tt.deleted)
+			}
+		})
+	}
+}
+
+func TestStreamTailStartsAfterExistingEntries(t *testing.T) {
+	r, _ := newTestRedis(t)
+	r.opTimeout = time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := r.StreamPublish(ctx, "ticks", []byte("k"), []byte("old"), nil, 0); err != nil {
+		t.Fatalf("StreamPublish failed: %v", err)
+	}
+
+	received := make(chan string, 4)
+	tail := r.NewStreamTail([]string{"ticks"})
+	tail.SetBlock(50 * time.Millisecond)
+	tail.Handle("ticks", MessageHandlerFunc(func(ctx context.Context, msg *Message) error {
+		received <- string(msg.Value)
+		return nil
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		tail.Run(ctx)
+		close(done)
+	}()
+
+	// Publish until the tail has started reading, every value after "old"
+	// must arrive and "old" never.
+	for _, value := range []string{"new1", "new2"} {
+		time.Sleep(100 * time.Millisecond)
+		if _, err := r.StreamPublish(ctx, "ticks", []byte("k"), []byte(value), nil, 0); err != nil {
+			t.Fatalf("StreamPublish failed: %v", err)
+		}
+	}
+
+	for _, want := range []string{"new1", "new2"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("received %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%q not received", want)
+		}
+	}
+
+	cancel()
+	<-done
+}
+
+func TestStreamConsumerDeadLettersAfterMaxDeliveries(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	c, err := r.NewStreamConsumer(ctx, "group", "worker", []string{"ticks"})
+	if err != nil {
+		t.Fatalf("NewStreamConsumer failed: %v", err)
+	}
+	c.SetClaimIdle(time.Millisecond)
+	c.SetMaxDeliveries(3)
+
+	failures := map[string]error{
+		"poison":  errors.New("malformed payload"),
+		"outage":  Transient(errors.New("connection refused")),
+		"healthy": nil,
+	}
+	c.Handle("ticks", MessageHandlerFunc(func(ctx context.Context, msg *Message) error {
+		return failures[string(msg.Value)]
+	}))
+
+	for _, value := range []string{"poison", "outage", "healthy"} {
+		if _, err := r.StreamPublish(ctx, "ticks", nil, []byte(value), nil, 0); err != nil {
+			t.Fatalf("StreamPublish failed: %v", err)
+		}
+	}
+
+	streams, err := r.Client.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "group", Consumer: "worker", Streams: []string{"ticks", ">"}}).Result()
+	if err != nil {
+		t.Fatalf("XReadGroup failed: %v", err)
+	}
+	for _, m := range streams[0].Messages {
+		c.dispatch(ctx, "ticks", m, 1)
+	}
+
+	// Two claims bring the failing entries to their third delivery.
+	for i := 0; i < 2; i++ {
+		time.Sleep(5 * time.Millisecond)
+		if err := c.claim(ctx, "ticks"); err != nil {
+			t.Fatalf("claim failed: %v", err)
+		}
+	}
+
+	pending, err := r.Client.XPendingExt(ctx, &redis.XPendingExtArgs{Stream: "ticks", Group: "group", Start: "-", End: "+", Count: 10}).Result()
+	if err != nil {
+		t.Fatalf("XPendingExt failed: %v", err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("%d entries pending, want only the transient failure", len(pending))
+	}
+
+	dead, err := r.Client.XRange(ctx, DeadLetterTopic("ticks"), "-", "+").Result()
+	if err != nil {
+		t.Fatalf("XRange failed: %v", err)
+	}
+	if len(dead) != 1 {
+		t.Fatalf("%d entries dead-lettered, want 1", len(dead))
+	}
+	msg := newStreamMessage(DeadLetterTopic("ticks"), dead[0])
+	if string(msg.Value) != "poison" || msg.Headers[HeaderDLQAttempts] != "3" || msg.Headers[HeaderDLQSourceTopic] != "ticks" {
+		t.Errorf("dead-lettered %q with headers %v", msg.Value, msg.Headers)
+	}
+}
+
+func TestAckDeletedPendingEntries(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	c, err := r.NewStreamConsumer(ctx, "group", "worker", []string{"ticks"})
+	if err != nil {
+		t.Fatalf("NewStreamConsumer failed: %v", err)
+	}
+
+	var ids []string
+	for _, value := range []string{"deleted", "kept"} {
+		id, err := r.StreamPublish(ctx, "ticks", nil, []byte(value), nil, 0)
+		if err != nil {
+			t.Fatalf("StreamPublish failed: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if _, err := r.Client.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "group", Consumer: "worker", Streams: []string{"ticks", ">"}}).Result(); err != nil {
+		t.Fatalf("XReadGroup failed: %v", err)
+	}
+	if err := r.Client.XDel(ctx, "ticks", ids[0]).Err(); err != nil {
+		t.Fatalf("XDel failed: %v", err)
+	}
+
+	if err := c.ackDeleted(ctx, "ticks", "0-0", "+", nil); err != nil {
+		t.Fatalf("ackDeleted failed: %v", err)
+	}
+
+	pending, err := r.Client.XPendingExt(ctx, &redis.XPendingExtArgs{Stream: "ticks", Group: "group", Start: "-", End: "+", Count: 10}).Result()
+	if err != nil {
+		t.Fatalf("XPendingExt failed: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != ids[1] {
+		t.Errorf("pending = %v, want only %s", pending, ids[1])
+	}
+}
@@ -0,0 +1,26 @@
+package transport
+
+import "testing"
+
+func TestEscapeGlob(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"", ""},
+		{"stream:topic1", "stream:topic1"},
+		{"stream:*", `stream:\*`},
+		{"a?b", `a\?b`},
+		{"[abc]", `\[abc\]`},
+		{`back\slash`, `back\\slash`},
+		{"ünïcode*", `ünïcode\*`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			if got := escapeGlob(tt.prefix); got != tt.want {
+				t.Errorf("escapeGlob(%q) = %q, want %q", tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package transport
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// GetAs decodes the value stored at key into T with the client codec.
+func GetAs[T any](ctx context.Context, r *RedisClient, key string) (T, error) {
+	return GetAsWith[T](ctx, r, r.codec, key)
+}
+
+// GetAsWith decodes the value stored at key into T with codec, e.g.
+// GetAsWith[*pb.Ticker](ctx, r, ProtobufCodec{}, key) for a value written
+// with SetWith and the same codec.
+func GetAsWith[T any](ctx context.Context, r *RedisClient, codec Codec, key string) (T, error) {
+	var value T
+
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	raw, err := r.Client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return value, ErrKeyNotFound
+	}
+	if err != nil {
+		return value, err
+	}
+
+	err = codec.Unmarshal(raw, &value)
+	return value, err
+}
+
+// GetListAs decodes the list elements between start and stop into T.
+func GetListAs[T any](ctx context.Context, r *RedisClient, key string, start, stop int64) ([]T, error) {
+	return GetListAsWith[T](ctx, r, r.codec, key, start, stop)
+}
+
+// GetListAsWith decodes the list elements between start and stop into T with
+// codec.
+func GetListAsWith[T any](ctx context.Context, r *RedisClient, codec Codec, key string, start, stop int64) ([]T, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	vals, err := r.Client.LRange(ctx, key, start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]T, 0, len(vals))
+	for _, val := range vals {
+		var value T
+		if err := codec.Unmarshal([]byte(val), &value); err != nil {
+			return nil, err
+		}
+		results = append(results, value)
+	}
+
+	return results, nil
+}
+
+// SetWith stores value at key encoded with codec instead of the client
+// codec, which is how protobuf messages are cached.
+func SetWith(ctx context.Context, r *RedisClient, codec Codec, key string, value interface{}, expiration ...time.Duration) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	encoded, err := codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	expire := time.Duration(0) // Default no expiration
+	if len(expiration) > 0 {
+		expire = expiration[0]
+	}
+
+	return r.Client.Set(ctx, key, encoded, expire).Err()
+}
+
+// PushWith prepends value to the list at key encoded with codec.
+func PushWith(ctx context.Context, r *RedisClient, codec Codec, key string, value interface{}) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	encoded, err := codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return r.Client.LPush(ctx, key, encoded).Err()
+}
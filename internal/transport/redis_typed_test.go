@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// newTestRedis returns a standalone client on an in-memory Redis.
+func newTestRedis(t *testing.T) (*RedisClient, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return &RedisClient{Client: client, codec: JSONCodec{}, mode: RedisStandalone}, mr
+}
+
+func TestProtobufPerCall(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+	codec := ProtobufCodec{}
+
+	if err := SetWith(ctx, r, codec, "ticker", wrapperspb.String("BTCUSDT")); err != nil {
+		t.Fatalf("SetWith failed: %v", err)
+	}
+	got, err := GetAsWith[*wrapperspb.StringValue](ctx, r, codec, "ticker")
+	if err != nil {
+		t.Fatalf("GetAsWith failed: %v", err)
+	}
+	if !proto.Equal(got, wrapperspb.String("BTCUSDT")) {
+		t.Errorf("GetAsWith = %v, want BTCUSDT", got)
+	}
+
+	for _, symbol := range []string{"BTCUSDT", "ETHUSDT"} {
+		if err := PushWith(ctx, r, codec, "history", wrapperspb.String(symbol)); err != nil {
+			t.Fatalf("PushWith failed: %v", err)
+		}
+	}
+	list, err := GetListAsWith[*wrapperspb.StringValue](ctx, r, codec, "history", 0, -1)
+	if err != nil {
+		t.Fatalf("GetListAsWith failed: %v", err)
+	}
+	if len(list) != 2 || list[0].GetValue() != "ETHUSDT" || list[1].GetValue() != "BTCUSDT" {
+		t.Errorf("GetListAsWith = %v, want [ETHUSDT BTCUSDT]", list)
+	}
+
+	if _, err := GetAsWith[*wrapperspb.StringValue](ctx, r, codec, "missing"); err != ErrKeyNotFound {
+		t.Errorf("GetAsWith of a missing key = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestGetAsClientCodec(t *testing.T) {
+	type ticker struct {
+		Symbol string  `json:"s" msgpack:"s"`
+		Price  float64 `json:"p" msgpack:"p"`
+	}
+
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			r, _ := newTestRedis(t)
+			r.codec = codec
+			ctx := context.Background()
+
+			in := ticker{Symbol: "BTCUSDT", Price: 30000.5}
+			if err := r.SetKeyValue(ctx, "ticker", in); err != nil {
+				t.Fatalf("SetKeyValue failed: %v", err)
+			}
+
+			out, err := GetAs[ticker](ctx, r, "ticker")
+			if err != nil {
+				t.Fatalf("GetAs failed: %v", err)
+			}
+			if out != in {
+				t.Errorf("GetAs = %+v, want %+v", out, in)
+			}
+		})
+	}
+}
@@ -2,10 +2,13 @@ package transport
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/health"
 )
 
 type Client struct {
@@ -57,6 +60,29 @@ func (c *Client) DoRequest(method, path string, body interface{}, headers map[st
 	return &HTTPResponse{Body: respBody, StatusCode: resp.StatusCode}, nil
 }
 
+func (c *Client) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create new request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("received unhealthy HTTP status: %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (c *Client) RegisterHealthCheck(registry *health.Registry, opts health.Options) error {
+	return registry.Register(health.Check{Name: "rest", Checker: c, Options: opts})
+}
+
 func (c *Client) SetBaseURL(baseURL string) {
 	c.BaseURL = baseURL
 }
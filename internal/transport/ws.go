@@ -1,25 +1,56 @@
 package transport
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/health"
 	"github.com/gorilla/websocket"
 )
 
 type WSClient struct {
 	URL          *url.URL
-	Connection   *websocket.Conn
+	connection   *websocket.Conn
 	PingPeriod   time.Duration
+	PongWait     time.Duration
 	MaxPingError int
-	MaxRetry     int
-	RetryWait    time.Duration
+	Reconnect    ReconnectPolicy
 	connected    bool
+	state        connectionState
+	dialer       *websocket.Dialer
+	header       http.Header
+	mu           sync.RWMutex
+	handlers     []MessageHandler
+	reconnect    chan struct{}
+	lastPong     time.Time
+	rtt          time.Duration
+	sendPolicy   SendPolicy
+	outbound     chan outboundMessage
+	control      chan outboundMessage
+	writerStop   chan struct{}
+	readBeat     *health.Beat
+	pingBeat     *health.Beat
+	readers      sync.WaitGroup
+	closed       bool
+
+	subscribeFrame   string
+	unsubscribeFrame string
+	subscriptions    map[string]struct{}
 }
 
-func NewWSClient(u string, pingPeriod time.Duration, maxPingError int, maxRetry int, retryWait time.Duration) *WSClient {
+// AssetPlaceholder is replaced by the asset symbol in subscription frames.
+const AssetPlaceholder = "{asset}"
+
+func NewWSClient(u string, pingPeriod time.Duration, pongWait time.Duration, maxPingError int, reconnect ReconnectPolicy) *WSClient {
 	wsurl, err := url.Parse(u)
 	if err != nil {
 		log.Fatalf("Failed to parse WebSocket server URL: %v", err)
@@ -28,73 +59,433 @@ func NewWSClient(u string, pingPeriod time.Duration, maxPingError int, maxRetry
 	return &WSClient{
 		URL:          wsurl,
 		PingPeriod:   pingPeriod,
+		PongWait:     pongWait,
 		MaxPingError: maxPingError,
-		MaxRetry:     maxRetry,
-		RetryWait:    retryWait,
+		Reconnect:    reconnect,
 		connected:    false,
+		reconnect:    make(chan struct{}, 1),
+		outbound:     make(chan outboundMessage, DefaultSendQueueSize),
+		control:      make(chan outboundMessage, 1),
+
+		subscriptions: make(map[string]struct{}),
+	}
+}
+
+// SetDialer replaces websocket.DefaultDialer and sets the headers sent with
+// the handshake. It must be called before Connect.
+func (w *WSClient) SetDialer(dialer *websocket.Dialer, header http.Header) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.dialer = dialer
+	w.header = header
+}
+
+// SetHeartbeats makes the read loop beat read on every frame and pong, and
+// the ping loop beat ping on every tick. Both pause while the connection is
+// down, and until the first connection. It must be called before Connect.
+func (w *WSClient) SetHeartbeats(read, ping *health.Beat) {
+	read.Pause()
+	ping.Pause()
+
+	w.readBeat = read
+	w.pingBeat = ping
+}
+
+// SetSubscriptionFrames configures the frames sent to (un)subscribe an asset,
+// every AssetPlaceholder in them is replaced by the asset symbol.
+func (w *WSClient) SetSubscriptionFrames(subscribe, unsubscribe string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.subscribeFrame = subscribe
+	w.unsubscribeFrame = unsubscribe
+}
+
+// Subscribe adds asset to the subscription registry and, when connected,
+// sends its subscribe frame. Registered assets are resubscribed after every
+// reconnect, so a failed send is only logged.
+func (w *WSClient) Subscribe(asset string) {
+	w.mu.Lock()
+	w.subscriptions[asset] = struct{}{}
+	frame := w.subscribeFrame
+	connected := w.connected
+	w.mu.Unlock()
+
+	if connected {
+		w.sendFrame(frame, asset)
+	}
+}
+
+// Unsubscribe removes asset from the registry and reports whether it was
+// subscribed.
+func (w *WSClient) Unsubscribe(asset string) bool {
+	w.mu.Lock()
+	_, ok := w.subscriptions[asset]
+	delete(w.subscriptions, asset)
+	frame := w.unsubscribeFrame
+	connected := w.connected
+	w.mu.Unlock()
+
+	if ok && connected {
+		w.sendFrame(frame, asset)
+	}
+	return ok
+}
+
+func (w *WSClient) Subscriptions() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	assets := make([]string, 0, len(w.subscriptions))
+	for asset := range w.subscriptions {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+
+	return assets
+}
+
+func (w *WSClient) resubscribe() {
+	w.mu.RLock()
+	frame := w.subscribeFrame
+	w.mu.RUnlock()
+
+	assets := w.Subscriptions()
+	for _, asset := range assets {
+		w.sendFrame(frame, asset)
+	}
+
+	if len(assets) > 0 {
+		log.Printf("Subscribed to %d assets on %s", len(assets), w.URL.Host)
+	}
+}
+
+func (w *WSClient) sendFrame(frame, asset string) {
+	if frame == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+	defer cancel()
+
+	data := []byte(strings.ReplaceAll(frame, AssetPlaceholder, asset))
+	if err := w.Send(ctx, data); err != nil {
+		log.Printf("Failed to send subscription frame for %s: %v", asset, err)
 	}
 }
 
-func (w *WSClient) Connect() error {
-	var err error
+// Handle registers a handler for every text and binary message read from the
+// server. Handlers run on the read loop and should not block.
+func (w *WSClient) Handle(handler MessageHandler) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	for i := 0; i < w.MaxRetry; i++ {
-		w.Connection, _, err = websocket.DefaultDialer.Dial(w.URL.String(), nil)
+	w.handlers = append(w.handlers, handler)
+}
+
+// Connect dials the server, retrying according to the reconnect policy
+// until it succeeds, gives up or ctx is done.
+func (w *WSClient) Connect(ctx context.Context) error {
+	err := w.Reconnect.Retry(ctx, "Connecting to wsserver "+w.URL.Host, func() error {
+		err := w.dial()
 		if err != nil {
-			w.connected = false
-			waitTime := time.Duration(i) * w.RetryWait * time.Second
-			fmt.Printf("Failed to connect wsserver, retry %d/%d, waiting %v before retrying\n", i+1, w.MaxRetry, waitTime)
-			time.Sleep(waitTime)
-		} else {
-			w.connected = true
-			break
+			w.setConnected(false)
+			w.state.failed(err)
 		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	w.state.connected()
+	return nil
+}
+
+func (w *WSClient) dial() error {
+	w.mu.RLock()
+	dialer, header := w.dialer, w.header
+	w.mu.RUnlock()
+
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
 	}
 
+	conn, _, err := dialer.Dial(w.URL.String(), header)
 	if err != nil {
-		return fmt.Errorf("failed to connect wsserver after %d retries: %v", w.MaxRetry, err)
+		return err
+	}
+
+	conn.SetCloseHandler(func(code int, text string) error {
+		log.Printf("WebSocket server sent close frame: %d %s", code, text)
+		message := websocket.FormatCloseMessage(code, "")
+		conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		return nil
+	})
+
+	// Only pongs extend the read deadline, so a peer that silently died is
+	// detected after PongWait even though pings can still be written.
+	conn.SetReadDeadline(time.Now().Add(w.PongWait))
+	conn.SetPongHandler(func(payload string) error {
+		w.pongReceived(payload)
+		w.readBeat.Beat()
+		return conn.SetReadDeadline(time.Now().Add(w.PongWait))
+	})
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		conn.Close()
+		return ErrClientClosed
+	}
+	if w.writerStop != nil {
+		close(w.writerStop)
 	}
+	w.writerStop = make(chan struct{})
+	w.connection = conn
+	w.connected = true
+	w.lastPong = time.Now()
+	stop := w.writerStop
+	w.readers.Add(1)
+	w.mu.Unlock()
+
+	w.readBeat.Beat()
+
+	go w.writePump(conn, stop)
+	go func() {
+		defer w.readers.Done()
+		w.readPump(conn)
+	}()
+
+	w.resubscribe()
 
 	return nil
 }
 
 func (w *WSClient) IsConnected() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
 	return w.connected
 }
 
-func (w *WSClient) MonitorConnection() {
+func (w *WSClient) setConnected(connected bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.connected = connected
+}
+
+func (w *WSClient) conn() *websocket.Conn {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.connection
+}
+
+// pongReceived records the round trip of the ping whose send time is carried
+// in the payload.
+func (w *WSClient) pongReceived(payload string) {
+	now := time.Now()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.lastPong = now
+	if sent, err := strconv.ParseInt(payload, 10, 64); err == nil {
+		w.rtt = now.Sub(time.Unix(0, sent))
+	}
+}
+
+func pingPayload() []byte {
+	return []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+func (w *WSClient) Check(ctx context.Context) error {
+	if err := w.state.err(); err != nil {
+		return err
+	}
+	if !w.IsConnected() {
+		return fmt.Errorf("websocket is not connected to %s", w.URL.Host)
+	}
+	return nil
+}
+
+func (w *WSClient) HealthDetails() map[string]interface{} {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	details := map[string]interface{}{
+		"url":    w.URL.Redacted(),
+		"rtt_ms": float64(w.rtt.Microseconds()) / 1000,
+	}
+	if !w.lastPong.IsZero() {
+		details["last_pong"] = w.lastPong.UTC()
+	}
+	w.state.details(details)
+	return details
+}
+
+func (w *WSClient) RegisterHealthCheck(registry *health.Registry, opts health.Options) error {
+	return registry.Register(health.Check{Name: "websocket", Checker: w, Options: opts})
+}
+
+// readPump reads from conn until it fails, dispatching data frames to the
+// registered handlers. Control frames are processed by gorilla while reading.
+func (w *WSClient) readPump(conn *websocket.Conn) {
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("WebSocket connection closed by server: %v", err)
+			} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				log.Printf("No pong from WebSocket server within %v, connection is dead", w.PongWait)
+			} else {
+				log.Printf("WebSocket read failed: %v", err)
+			}
+			w.connectionLost(conn)
+			return
+		}
+
+		w.readBeat.Beat()
+		w.dispatch(messageType, data)
+	}
+}
+
+func (w *WSClient) dispatch(messageType int, data []byte) {
+	kind := "text"
+	if messageType == websocket.BinaryMessage {
+		kind = "binary"
+	}
+
+	msg := &Message{
+		Source:    "websocket",
+		Topic:     "websocket",
+		Value:     data,
+		Headers:   map[string]string{"type": kind},
+		Timestamp: time.Now(),
+	}
+
+	w.mu.RLock()
+	handlers := w.handlers
+	w.mu.RUnlock()
+
+	for _, h := range handlers {
+		if err := h.HandleMessage(context.Background(), msg); err != nil {
+			log.Printf("WebSocket message handler failed: %v", err)
+		}
+	}
+}
+
+// connectionLost asks MonitorConnection to reconnect, unless conn has
+// already been replaced or the client was closed.
+func (w *WSClient) connectionLost(conn *websocket.Conn) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed || w.connection != conn {
+		return
+	}
+	w.connected = false
+	w.readBeat.Pause()
+
+	select {
+	case w.reconnect <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the client for good: it tells the server it is going away,
+// closes the connection and waits until the read loop, and so the message
+// handlers, have returned. MonitorConnection must be stopped through its ctx
+// first.
+func (w *WSClient) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	w.connected = false
+	if w.writerStop != nil {
+		close(w.writerStop)
+		w.writerStop = nil
+	}
+	conn := w.connection
+	w.mu.Unlock()
+
+	w.readBeat.Pause()
+	w.pingBeat.Pause()
+
+	if conn != nil {
+		message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+		conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+		conn.Close()
+	}
+
+	w.readers.Wait()
+	log.Println("WebSocket client closed")
+}
+
+// MonitorConnection pings the server and reconnects when the connection is
+// lost, until ctx is done.
+func (w *WSClient) MonitorConnection(ctx context.Context) {
 	ticker := time.NewTicker(w.PingPeriod)
 	defer ticker.Stop()
 
 	pingFailures := 0
-	retries := 0
+
+	// redial retries according to the reconnect policy. Once it gives up the
+	// health check reports the client as failed, and redial keeps probing
+	// every MaxInterval until the server is back.
+	redial := func() {
+		w.pingBeat.Pause()
+		w.conn().Close()
+		err := w.Connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Giving up on WebSocket server, probing every %v: %v", w.Reconnect.MaxInterval, err)
+			w.state.giveUp(err)
+		}
+		for err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(w.Reconnect.ProbeDelay()):
+			}
+			if err = w.dial(); err != nil {
+				w.state.failed(err)
+			}
+		}
+		w.state.connected()
+		log.Println("Re-connected to WebSocket successfully")
+		pingFailures = 0
+		w.pingBeat.Beat()
+	}
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
-			err := w.Connection.WriteMessage(websocket.PingMessage, nil)
+			w.pingBeat.Beat()
+			err := w.ping()
 			if err != nil {
 				log.Println("Failed to send ping:", err)
 				pingFailures++
 				if pingFailures >= w.MaxPingError {
-					w.Connection.Close()
 					log.Println("Max ping failures reached, reconnecting...")
-					err = w.Connect()
-					if err != nil {
-						retries++
-						if retries >= w.MaxRetry {
-							log.Println("Failed to reconnect after max retries")
-							return
-						}
-					} else {
-						log.Println("Re-connected to WebSocket successfully")
-						pingFailures = 0
-						retries = 0
-					}
+					redial()
 				}
 			} else {
 				pingFailures = 0
 			}
+		case <-w.reconnect:
+			log.Println("WebSocket connection lost, reconnecting...")
+			redial()
 		}
 	}
 }
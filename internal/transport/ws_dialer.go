@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/denizumutdereli/golang-K8-microservice-probs/internal/config"
+	"github.com/gorilla/websocket"
+)
+
+// NewWSDialer builds the dialer and handshake headers for the upstream
+// WebSocket server from the WS_* settings.
+func NewWSDialer(cnf *config.Config) (*websocket.Dialer, http.Header, error) {
+	dialer := &websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		HandshakeTimeout:  time.Duration(cnf.WsHandshakeTimeout) * time.Millisecond,
+		Subprotocols:      cnf.WsSubprotocols,
+		EnableCompression: cnf.WsCompression,
+	}
+
+	if cnf.WsProxyURL != "" {
+		proxyURL, err := url.Parse(cnf.WsProxyURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse websocket proxy URL: %v", err)
+		}
+		dialer.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := wsTLSConfig(cnf)
+	if err != nil {
+		return nil, nil, err
+	}
+	dialer.TLSClientConfig = tlsConfig
+
+	header := http.Header{}
+	for key, value := range cnf.WsHeaders {
+		header.Set(key, value)
+	}
+
+	if cnf.WsBearerToken != "" {
+		header.Set("Authorization", "Bearer "+cnf.WsBearerToken)
+	}
+
+	if cnf.WsAPIKey != "" {
+		header.Set(cnf.WsAPIKeyHeader, cnf.WsAPIKey)
+	}
+
+	return dialer, header, nil
+}
+
+func wsTLSConfig(cnf *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cnf.WsTLSServerName,
+		InsecureSkipVerify: cnf.WsTLSInsecureSkipVerify,
+	}
+
+	if cnf.WsTLSCAFile != "" {
+		pem, err := os.ReadFile(cnf.WsTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read websocket CA file: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in websocket CA file %s", cnf.WsTLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cnf.WsTLSCertFile != "" || cnf.WsTLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cnf.WsTLSCertFile, cnf.WsTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load websocket client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
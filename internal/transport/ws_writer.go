@@ -0,0 +1,136 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	DefaultSendQueueSize = 256
+
+	// writeWait bounds a single frame write, and how long internal senders
+	// wait for room in the queue.
+	writeWait = 10 * time.Second
+)
+
+type SendPolicy int
+
+const (
+	// SendBlock makes Send wait for room in the queue until its context ends.
+	SendBlock SendPolicy = iota
+	// SendDrop makes Send fail immediately with ErrSendQueueFull.
+	SendDrop
+)
+
+var (
+	ErrSendQueueFull = errors.New("websocket send queue is full")
+	ErrNotConnected  = errors.New("websocket is not connected")
+	ErrClientClosed  = errors.New("websocket client is closed")
+)
+
+func ParseSendPolicy(policy string) (SendPolicy, error) {
+	switch policy {
+	case "", "block":
+		return SendBlock, nil
+	case "drop":
+		return SendDrop, nil
+	}
+	return SendBlock, fmt.Errorf("unknown websocket send policy %q", policy)
+}
+
+type outboundMessage struct {
+	messageType int
+	data        []byte
+}
+
+// SetSendQueue resizes the outbound queue and sets what Send does when it is
+// full. It must be called before Connect.
+func (w *WSClient) SetSendQueue(size int, policy SendPolicy) {
+	if size <= 0 {
+		size = DefaultSendQueueSize
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.outbound = make(chan outboundMessage, size)
+	w.sendPolicy = policy
+}
+
+// Send queues a text message for the writer goroutine, which is the only
+// one writing to the connection. Queued messages survive a reconnect.
+func (w *WSClient) Send(ctx context.Context, msg []byte) error {
+	w.mu.RLock()
+	connected, policy, queue := w.connected, w.sendPolicy, w.outbound
+	w.mu.RUnlock()
+
+	if !connected {
+		return ErrNotConnected
+	}
+
+	m := outboundMessage{messageType: websocket.TextMessage, data: msg}
+
+	if policy == SendDrop {
+		select {
+		case queue <- m:
+			return nil
+		default:
+			return ErrSendQueueFull
+		}
+	}
+
+	select {
+	case queue <- m:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ping hands a ping to the writer ahead of queued data. It fails when the
+// previous ping has not been written yet.
+func (w *WSClient) ping() error {
+	select {
+	case w.control <- outboundMessage{messageType: websocket.PingMessage, data: pingPayload()}:
+		return nil
+	default:
+		return errors.New("previous ping is still pending")
+	}
+}
+
+// writePump writes queued frames to conn until stop is closed or a write
+// fails, in which case the connection is reported lost.
+func (w *WSClient) writePump(conn *websocket.Conn, stop chan struct{}) {
+	w.mu.RLock()
+	queue := w.outbound
+	w.mu.RUnlock()
+
+	for {
+		var m outboundMessage
+
+		select {
+		case <-stop:
+			return
+		case m = <-w.control:
+		default:
+			select {
+			case <-stop:
+				return
+			case m = <-w.control:
+			case m = <-queue:
+			}
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := conn.WriteMessage(m.messageType, m.data); err != nil {
+			log.Printf("WebSocket write failed: %v", err)
+			w.connectionLost(conn)
+			return
+		}
+	}
+}